@@ -1,12 +1,17 @@
 package deferclient
 
 import (
+	"crypto/rand"
+	"fmt"
 	"net"
 	"os"
 	"runtime"
 	"strconv"
 )
 
+// instanceId is the default InstanceId of all clients in this process
+var instanceId = newUUID()
+
 // Mem simply grabs the total memory avail on the system
 type Mem struct {
 	Total uint64
@@ -66,3 +71,17 @@ func NewAgent() *Agent {
 
 	return a
 }
+
+// newUUID returns a random (version 4) uuid
+func newUUID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
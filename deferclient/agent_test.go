@@ -36,3 +36,15 @@ func TestNewAgent(t *testing.T) {
 		t.Error("not creating api version")
 	}
 }
+
+func TestNewUUID(t *testing.T) {
+	u := newUUID()
+
+	if len(u) != 36 || u[14] != '4' {
+		t.Errorf("not a version 4 uuid %v", u)
+	}
+
+	if u == newUUID() {
+		t.Error("not generating random uuids")
+	}
+}
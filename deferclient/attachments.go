@@ -0,0 +1,128 @@
+package deferclient
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"sort"
+)
+
+const (
+	// attachmentsPath is the path the attachments of reports are uploaded
+	// to, linked to their report by its ReportId
+	attachmentsPath = "/uploads/attachments/create"
+
+	// maxAttachments caps the number of attachments of a report
+	maxAttachments = 5
+
+	// maxAttachmentsSize caps the total size of the attachments of a
+	// report
+	maxAttachmentsSize = 1 << 20
+)
+
+// PrepWithAttachments takes an error, a spanId && attachments by name,
+// eg: the input that made a parser panic
+// attachments over the count && size caps are dropped, the rest are
+// uploaded as multipart once the report is sent
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepWithAttachments(err interface{}, spanId int64, attachments map[string][]byte) {
+	if c.discarded(err) {
+		return
+	}
+
+	dj := c.newDeferJSON(err, spanId)
+	dj.Attachments = capAttachments(attachments)
+	if len(dj.Attachments) > 0 {
+		dj.ReportId = newUUID()
+	}
+
+	c.ship(dj, false)
+}
+
+// uploadAttachments uploads the attachments of dj, if any, as multipart
+// linked to dj by its ReportId
+func (c *DeferPanicClient) uploadAttachments(dj *DeferJSON) {
+	if len(dj.Attachments) == 0 {
+		return
+	}
+
+	b, contentType, err := attachmentsMultipart(dj.ReportId, dj.Attachments)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	ctx := withHeaders(ContextWithToken(context.Background(), dj.Token), http.Header{
+		"Content-Type": {contentType},
+	})
+
+	c.upload(ctx, b, ReportAttachments)
+}
+
+// attachmentsMultipart returns the multipart body of attachments, a
+// ReportId field followed by a file part per attachment in name order
+func attachmentsMultipart(reportId string, attachments map[string][]byte) ([]byte, string, error) {
+	names := make([]string, 0, len(attachments))
+	for name := range attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	err := mw.WriteField("ReportId", reportId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, name := range names {
+		pw, err := mw.CreateFormFile("attachments", name)
+		if err != nil {
+			return nil, "", err
+		}
+
+		_, err = pw.Write(attachments[name])
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = mw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// capAttachments returns the attachments, in name order, that fit the
+// count && size caps
+func capAttachments(attachments map[string][]byte) map[string][]byte {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(attachments))
+	for name := range attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	capped := make(map[string][]byte)
+	size := 0
+	for _, name := range names {
+		b := attachments[name]
+		if len(capped) == maxAttachments || size+len(b) > maxAttachmentsSize {
+			log.Println("dropping the attachment " + name + " over the caps")
+			continue
+		}
+
+		capped[name] = b
+		size += len(b)
+	}
+
+	return capped
+}
@@ -0,0 +1,91 @@
+package deferclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCapAttachments(t *testing.T) {
+	attachments := make(map[string][]byte)
+	for i := 0; i < maxAttachments+2; i++ {
+		attachments["file"+strconv.Itoa(i)] = []byte("data")
+	}
+
+	if len(capAttachments(attachments)) != maxAttachments {
+		t.Error("not capping the attachment count")
+	}
+
+	capped := capAttachments(map[string][]byte{
+		"big":   make([]byte, maxAttachmentsSize),
+		"small": []byte("data"),
+	})
+	if len(capped) != 1 || capped["big"] == nil {
+		t.Error("not capping the attachments size")
+	}
+}
+
+func TestPrepWithAttachments(t *testing.T) {
+	var reports = make(chan []byte, 1)
+	var uploads = make(chan *multipart.Form, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == attachmentsPath {
+			err := r.ParseMultipartForm(1 << 20)
+			if err != nil {
+				t.Error(err)
+			}
+			uploads <- r.MultipartForm
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		reports <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.PrepWithAttachments("bad input", 0, map[string][]byte{"input.csv": []byte("a,b,")})
+
+	body := <-reports
+	if bytes.Contains(body, []byte(`"Attachments"`)) {
+		t.Error("embedding the attachments in the report")
+	}
+
+	var dj DeferJSON
+	err := json.Unmarshal(body, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.ReportId == "" {
+		t.Error("not linking the report to its attachments")
+	}
+
+	form := <-uploads
+	if id := form.Value["ReportId"]; len(id) != 1 || id[0] != dj.ReportId {
+		t.Errorf("not linking the attachments to the report, got %v", id)
+	}
+
+	files := form.File["attachments"]
+	if len(files) != 1 || files[0].Filename != "input.csv" {
+		t.Fatalf("not uploading each attachment as a file, got %v", files)
+	}
+
+	f, err := files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, _ := ioutil.ReadAll(f)
+	if string(b) != "a,b," {
+		t.Errorf("not uploading the attachment as is, got %q", b)
+	}
+}
@@ -0,0 +1,97 @@
+package deferclient
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+const (
+	// BaggageHeader is the header baggage is propagated in across
+	// services, as comma separated key=value pairs
+	BaggageHeader = "X-Dpbaggage"
+
+	// maxBaggageSize caps the size of the baggage header, pairs over it
+	// are dropped
+	maxBaggageSize = 1024
+)
+
+// ContextWithBaggage returns a copy of ctx carrying baggage, the key/value
+// pairs propagated across services && reported along w/panics, eg: the
+// tenant or feature flags
+func ContextWithBaggage(ctx context.Context, baggage map[string]string) context.Context {
+	return context.WithValue(ctx, baggageKey, baggage)
+}
+
+// BaggageFromContext returns the baggage carried by ctx, if any
+func BaggageFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+
+	baggage, _ := ctx.Value(baggageKey).(map[string]string)
+	return baggage
+}
+
+// ParseBaggage returns the baggage of a BaggageHeader value, malformed
+// pairs && pairs over maxBaggageSize are dropped
+func ParseBaggage(header string) map[string]string {
+	if len(header) > maxBaggageSize {
+		header = header[:maxBaggageSize]
+		// drop the cut pair
+		if i := strings.LastIndex(header, ","); i >= 0 {
+			header = header[:i]
+		} else {
+			header = ""
+		}
+	}
+
+	var baggage map[string]string
+	for _, pair := range strings.Split(header, ",") {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			continue
+		}
+
+		k := strings.TrimSpace(pair[:i])
+		if k == "" {
+			continue
+		}
+
+		if baggage == nil {
+			baggage = make(map[string]string)
+		}
+		baggage[k] = strings.TrimSpace(pair[i+1:])
+	}
+
+	return baggage
+}
+
+// FormatBaggage returns the BaggageHeader value of baggage, keys sorted,
+// pairs over maxBaggageSize && pairs that wouldn't parse back, eg: w/a
+// comma, are dropped
+func FormatBaggage(baggage map[string]string) string {
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		if k == "" || strings.ContainsAny(k, ",=") || strings.Contains(baggage[k], ",") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		pair := k + "=" + baggage[k]
+		if b.Len() > 0 {
+			pair = "," + pair
+		}
+
+		if b.Len()+len(pair) > maxBaggageSize {
+			break
+		}
+		b.WriteString(pair)
+	}
+
+	return b.String()
+}
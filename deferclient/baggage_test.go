@@ -0,0 +1,49 @@
+package deferclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseBaggage(t *testing.T) {
+	baggage := ParseBaggage("tenant=acme, flag = on,malformed,=empty")
+	if len(baggage) != 2 || baggage["tenant"] != "acme" || baggage["flag"] != "on" {
+		t.Errorf("not parsing the baggage, got %v", baggage)
+	}
+
+	if ParseBaggage("") != nil {
+		t.Error("parsing baggage out of nothing")
+	}
+
+	long := "a=1," + strings.Repeat("b", maxBaggageSize)
+	if baggage := ParseBaggage(long); len(baggage) != 1 || baggage["a"] != "1" {
+		t.Errorf("not capping the baggage, got %v", baggage)
+	}
+}
+
+func TestFormatBaggage(t *testing.T) {
+	header := FormatBaggage(map[string]string{"tenant": "acme", "flag": "on", "bad,key": "x"})
+	if header != "flag=on,tenant=acme" {
+		t.Errorf("not formatting the baggage, got %q", header)
+	}
+
+	big := map[string]string{"a": "1", "b": strings.Repeat("x", maxBaggageSize)}
+	if header := FormatBaggage(big); header != "a=1" {
+		t.Errorf("not capping the baggage, got %q", header)
+	}
+}
+
+func TestBaggageContext(t *testing.T) {
+	if BaggageFromContext(context.Background()) != nil {
+		t.Error("baggage out of an empty context")
+	}
+
+	ctx := ContextWithBaggage(context.Background(), map[string]string{"tenant": "acme"})
+
+	c := NewDeferPanicClient("token")
+	dj := c.newDeferJSONContext(ctx, "test")
+	if dj.Baggage["tenant"] != "acme" {
+		t.Error("not attaching the baggage to the report")
+	}
+}
@@ -0,0 +1,126 @@
+package deferclient
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// defaultBatchSize is the BatchSize used when none is set
+const defaultBatchSize = 100
+
+// buffer adds the encoded report b to the next batch, starting the batch
+// flusher if it isn't running yet
+func (c *DeferPanicClient) buffer(b []byte) {
+	size := c.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	c.Lock()
+	c.batched = append(c.batched, b)
+	full := len(c.batched) >= size
+
+	if c.batchStop == nil {
+		c.batchStop = make(chan bool)
+		go c.flushBatches(c.BatchInterval, c.batchStop)
+	}
+	c.Unlock()
+
+	if full {
+		c.postBatch()
+	}
+}
+
+// flushBatches POSTs the buffered reports every interval until stop is
+// closed
+func (c *DeferPanicClient) flushBatches(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.postBatch()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// postBatch POSTs all buffered reports as a single json array
+func (c *DeferPanicClient) postBatch() {
+	c.Lock()
+	batched := c.batched
+	c.batched = nil
+	c.Unlock()
+
+	if len(batched) == 0 {
+		return
+	}
+
+	c.postit(withPriority(context.Background()), joinBatch(batched), c.APIURL(panicsBatchPath), ReportPanicBatch, false)
+}
+
+// joinBatch joins encoded reports into a json array
+func joinBatch(batched [][]byte) []byte {
+	b := append([]byte("["), bytes.Join(batched, []byte(","))...)
+	return append(b, ']')
+}
+
+// ReportBatch POSTs reports in a single request, eg: to replay reports
+// buffered while reporting was disabled
+// it returns an error if the batch wasn't delivered
+func (c *DeferPanicClient) ReportBatch(reports []DeferJSON) error {
+	if c.noPost() || len(reports) == 0 {
+		return nil
+	}
+
+	c.Lock()
+	token := c.Token
+	c.Unlock()
+
+	if token == "" && !c.AllowEmptyToken {
+		return ErrNoToken
+	}
+
+	batched := make([][]byte, 0, len(reports))
+	for i := range reports {
+		dj := reports[i]
+		c.fill(&dj)
+
+		b, err := c.encode(&dj)
+		if err != nil {
+			return err
+		}
+
+		batched = append(batched, b)
+	}
+
+	ctx := withPriority(context.Background())
+	if c.Reporter != nil {
+		return c.report(ctx, joinBatch(batched), ReportPanicBatch)
+	}
+
+	resp, body, err := c.postitResult(ctx, joinBatch(batched), c.APIURL(panicsBatchPath))
+	if err != nil && !isErr(err, ErrResponseTooLarge) {
+		return err
+	}
+
+	return c.handleStatus(resp.StatusCode, body)
+}
+
+// Close stops the batch flusher and POSTs any reports still buffered or
+// grouped && the bundled traces/profiles
+func (c *DeferPanicClient) Close() {
+	c.Lock()
+	if c.batchStop != nil {
+		close(c.batchStop)
+		c.batchStop = nil
+	}
+	c.Unlock()
+
+	c.postGroups()
+	c.postBundle()
+	c.postBatch()
+}
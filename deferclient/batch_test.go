@@ -0,0 +1,96 @@
+package deferclient
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	var resbody = make(chan []byte, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != panicsBatchPath {
+			t.Errorf("not posting to the batch path %v", r.URL.Path)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.BatchInterval = time.Hour
+	c.BatchSize = 2
+	defer c.Close()
+
+	c.ShipTrace("trace", "one", 0)
+	c.ShipTrace("trace", "two", 0)
+	c.ShipTrace("trace", "three", 0)
+
+	var djs []DeferJSON
+	err := json.Unmarshal(<-resbody, &djs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(djs) != 2 || djs[0].Msg != "one" || djs[1].Msg != "two" {
+		t.Error("not posting a full batch")
+	}
+
+	if !c.Flush(2 * time.Second) {
+		t.Error("not flushing in time")
+	}
+
+	err = json.Unmarshal(<-resbody, &djs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(djs) != 1 || djs[0].Msg != "three" {
+		t.Error("not draining the batch on flush")
+	}
+}
+
+func TestReportBatch(t *testing.T) {
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail"+panicsBatchPath {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.InstanceId = "web-1"
+
+	err := c.ReportBatch([]DeferJSON{{Msg: "one"}, {Msg: "two"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var djs []DeferJSON
+	err = json.Unmarshal(<-resbody, &djs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(djs) != 2 || djs[1].Msg != "two" || djs[0].InstanceId != "web-1" {
+		t.Error("not posting the reports in a single batch")
+	}
+
+	c.SetBaseURL(ts.URL + "/fail")
+	if c.ReportBatch([]DeferJSON{{Msg: "one"}}) == nil {
+		t.Error("not returning an error for an undelivered batch")
+	}
+}
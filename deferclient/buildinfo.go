@@ -0,0 +1,29 @@
+//go:build go1.18
+// +build go1.18
+
+package deferclient
+
+import (
+	"runtime/debug"
+)
+
+// buildInfo returns the main module version && the vcs revision the
+// binary was built from, if embedded
+func buildInfo() (version string, revision string) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+
+	if bi.Main.Version != "(devel)" {
+		version = bi.Main.Version
+	}
+
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			revision = s.Value
+		}
+	}
+
+	return version, revision
+}
@@ -0,0 +1,130 @@
+package deferclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"time"
+)
+
+const (
+	// profilesBundlePath is the path BundleProfiles uploads goto
+	profilesBundlePath = "/uploads/bundle/create"
+
+	// defaultBundleWindow is the BundleWindow used when none is set, long
+	// enough for a cpu profile started along w/the first trace/profile
+	defaultBundleWindow = 45 * time.Second
+)
+
+// bundledProfile is a trace/profile waiting in the bundle
+type bundledProfile struct {
+	kind ReportKind
+	b    []byte
+}
+
+// bundleProfile adds the trace/profile b of kind to the bundle, starting
+// the bundle window if it is the first one
+func (c *DeferPanicClient) bundleProfile(b []byte, kind ReportKind) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.bundled = append(c.bundled, bundledProfile{kind: kind, b: b})
+	if c.bundleTimer != nil {
+		return
+	}
+
+	window := c.BundleWindow
+	if window <= 0 {
+		window = defaultBundleWindow
+	}
+
+	// so Flush waits for the bundle
+	c.pending++
+	c.bundleTimer = time.AfterFunc(window, c.uploadBundle)
+}
+
+// postBundle ends the bundle window right away, uploading the bundle,
+// eg: on Flush or Close
+func (c *DeferPanicClient) postBundle() {
+	c.Lock()
+	timer := c.bundleTimer
+	c.Unlock()
+
+	// else the window is ending already
+	if timer != nil && timer.Stop() {
+		c.uploadBundle()
+	}
+}
+
+// uploadBundle ends the bundle window, uploading the traces/profiles in
+// the bundle together
+func (c *DeferPanicClient) uploadBundle() {
+	c.Lock()
+	bundled := c.bundled
+	c.bundled = nil
+	windowed := c.bundleTimer != nil
+	c.bundleTimer = nil
+	c.Unlock()
+
+	if windowed {
+		defer c.track(-1)
+	}
+
+	if len(bundled) == 0 {
+		return
+	}
+
+	b, contentType, err := gzipMultipart(bundled)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	ctx := withHeaders(context.Background(), http.Header{
+		"Content-Type":     {contentType},
+		"Content-Encoding": {"gzip"},
+	})
+
+	c.upload(ctx, b, ReportProfileBundle)
+}
+
+// gzipMultipart returns the gzipped multipart body of bundled, each part
+// is named after the upload path of its trace/profile, eg: cpuprofile
+func gzipMultipart(bundled []bundledProfile) ([]byte, string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	mw := multipart.NewWriter(zw)
+
+	for _, bp := range bundled {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="`+path.Base(path.Dir(kindPath(bp.kind)))+`"`)
+		h.Set("Content-Type", "application/json")
+
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+
+		_, err = pw.Write(bp.b)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
@@ -0,0 +1,111 @@
+package deferclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadBundle(t *testing.T) {
+	type part struct {
+		name string
+		body string
+	}
+	var resparts = make(chan []part, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != profilesBundlePath || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Error("not uploading a gzipped bundle")
+		}
+
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			resparts <- nil
+			return
+		}
+
+		var parts []part
+		mr := multipart.NewReader(zr, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			body, _ := ioutil.ReadAll(p)
+			parts = append(parts, part{p.FormName(), string(body)})
+		}
+		resparts <- parts
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.bundled = []bundledProfile{
+		{kind: ReportCPUProfile, b: []byte(`{"cpu":1}`)},
+		{kind: ReportMemProfile, b: []byte(`{"mem":1}`)},
+	}
+
+	c.uploadBundle()
+
+	parts := <-resparts
+	if len(parts) != 2 || parts[0].name != "cpuprofile" || parts[1].body != `{"mem":1}` {
+		t.Errorf("not bundling the profiles, got %v", parts)
+	}
+}
+
+func TestBundleProfile(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.BundleProfiles = true
+
+	c.uploadProfile([]byte("{}"), ReportCPUProfile)
+	c.uploadProfile([]byte("{}"), ReportMemProfile)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.bundled) != 2 || !bytes.Equal(c.bundled[1].b, []byte("{}")) {
+		t.Error("not bundling the profiles")
+	}
+}
+
+func TestFlushBundle(t *testing.T) {
+	var respath = make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respath <- r.URL.Path
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.BundleProfiles = true
+	c.BundleWindow = time.Hour
+
+	c.uploadProfile([]byte("{}"), ReportCPUProfile)
+	if !c.Flush(2 * time.Second) {
+		t.Fatal("waiting for the BundleWindow to flush")
+	}
+
+	if len(respath) != 1 || <-respath != profilesBundlePath {
+		t.Error("not uploading the bundle on flush")
+	}
+
+	c.uploadProfile([]byte("{}"), ReportMemProfile)
+	c.Close()
+
+	if len(respath) != 1 || len(c.bundled) != 0 {
+		t.Error("not uploading the bundle on close")
+	}
+}
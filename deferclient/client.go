@@ -3,14 +3,21 @@ package deferclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -23,17 +30,44 @@ const (
 	// UserAgent is the User Agent that is used with this client
 	UserAgent = "deferclient " + ApiVersion
 
-	// errorsUrl is the url to post panics && errors to
-	errorsUrl = ApiBase + "/panics/create"
+	// errorsPath is the path to post panics && errors to
+	errorsPath = "/panics/create"
 
-	// cpuprofileUrl is the url to post cpuprofiles to
-	cpuprofileUrl = ApiBase + "/uploads/cpuprofile/create"
+	// cpuprofilePath is the path to post cpuprofiles to
+	cpuprofilePath = "/uploads/cpuprofile/create"
 
-	// memprofileUrl is the url to post memprofiles to
-	memprofileUrl = ApiBase + "/uploads/memprofile/create"
+	// panicsBatchPath is the path to post batches of panics && errors to
+	panicsBatchPath = "/panics/batch/create"
 
-	// traceUrl is the url to post traces to
-	traceUrl = ApiBase + "/uploads/trace/create"
+	// memprofilePath is the path to post memprofiles to
+	memprofilePath = "/uploads/memprofile/create"
+
+	// tracePath is the path to post traces to
+	tracePath = "/uploads/trace/create"
+
+	// StatsPath is the path to post deferstats reports to
+	StatsPath = "/stats/create"
+
+	// AgentPath is the path new agents check in at
+	AgentPath = "/agent_ids/create"
+)
+
+const (
+	// CategoryRuntime is the Category of runtime errors, eg: nil pointer
+	// dereferences && out of range indexes
+	CategoryRuntime = "runtime"
+
+	// CategoryError is the Category of panics w/an error value
+	CategoryError = "error"
+
+	// CategoryMessage is the Category of panics w/a string value
+	CategoryMessage = "message"
+
+	// CategoryOther is the Category of panics w/any other value
+	CategoryOther = "other"
+
+	// CategoryNote is the Category of the non-fatal events sent w/Note
+	CategoryNote = "note"
 )
 
 // being DEPRECATED
@@ -48,6 +82,19 @@ var (
 	NoPost = false
 )
 
+// defaultPrintLimit is the PrintLimit used when none is set
+const defaultPrintLimit = 64 << 10
+
+// defaultMaxResponseBytes is the MaxResponseBytes used when none is set
+const defaultMaxResponseBytes = 1 << 20
+
+// defaultProfileUploadTimeout is the ProfileUploadTimeout of clients that
+// have none set
+const defaultProfileUploadTimeout = 2 * time.Minute
+
+// defaultHttpClient is used by clients that have no HttpClient set
+var defaultHttpClient = &http.Client{Timeout: 30 * time.Second}
+
 // DeferPanicClient is the base struct for making requests to the defer
 // panic api
 //
@@ -58,21 +105,310 @@ type DeferPanicClient struct {
 	Environment string
 	AppGroup    string
 
-	Agent       *Agent
+	// BaseURL is the base url that requests goto - default is ApiBase
+	BaseURL string
+
+	// InstanceId identifies this instance of the app on every report,
+	// eg: the pod name - default is a random uuid for the process
+	InstanceId string
+
+	// BuildVersion && BuildRevision tie every report to the build that
+	// produced it - default is the module version && vcs revision
+	// embedded by go1.18+, set them for builds w/o vcs info
+	BuildVersion  string
+	BuildRevision string
+
+	// RecentRequests returns the recent http requests attached to each
+	// panic report, eg: set by deferstats KeepRecentRequests - default is
+	// nil (none attached)
+	RecentRequests func() json.RawMessage
+
+	// ContextFields extracts the fields attached to the reports of panics
+	// recovered w/a context, eg: the attributes of a request scoped slog
+	// logger - default is nil (no fields)
+	ContextFields func(ctx context.Context) map[string]string
+
+	// RuntimeInfo is sent w/every report, it is captured once by
+	// NewDeferPanicClient - set it to nil to not send it, eg: for privacy
+	RuntimeInfo *RuntimeInfo
+
+	Agent *Agent
+
+	// NoPost disables posting && PrintPanics prints the stack of each
+	// panic - toggle them w/SetNoPost && SetPrintPanics once the client
+	// is in use
 	NoPost      bool
 	PrintPanics bool
 
+	// PrintLimit bounds the stack PrintPanics prints, in bytes, so a deep
+	// stack doesn't flood stdout - default is 64KB
+	PrintLimit int
+
+	// MaxFrames keeps only the top frames of each backtrace - default is
+	// 0 (all frames)
+	MaxFrames int
+
+	// StackFormatter formats the captured backtrace of each panic, eg:
+	// for collectors expecting another format, in place of cleanTrace -
+	// default is nil (deferpanic's format)
+	StackFormatter func(raw []byte) string
+
+	// SkipStack sends reports w/o a backtrace, eg: for high volume errors
+	// where capturing the stack would dominate the cost - see also
+	// PrepNoStack
+	SkipStack bool
+
+	// GrabResources determines if we should grab the open fd count &&
+	// resident memory of the process w/each panic (linux only)
+	GrabResources bool
+
+	// GrabGC determines if we should grab a summary of the recent gc
+	// pauses w/each panic, eg: to spot panics under gc pressure
+	GrabGC bool
+
+	// ResolveOrigin sets OriginFunc, OriginFile && OriginLine of each
+	// report from the program counters of the panicking goroutine -
+	// default is false
+	ResolveOrigin bool
+
 	HttpClient *http.Client
 
+	// Reporter sends the reports instead of POSTing them to the api, eg:
+	// over a message queue to a relay - default is nil (POST over http)
+	// the api's responses, && thus its commands, are not seen w/one
+	// see NewTeeReporter to send them to several && NewHTTPReporter to
+	// keep POSTing them along
+	Reporter Reporter
+
+	// MaxPostsPerSecond caps the POSTs of panics, stats && profiles, over
+	// it panic reports wait while the others are dropped - default is 0
+	// (no limit)
+	MaxPostsPerSecond float64
+	limiter           *TokenBucketSampler
+
+	// StatusHandler is called w/the status && body of every response
+	// from the api, an error marks the POST as not delivered && is
+	// logged - default treats any non-2xx as not delivered
+	StatusHandler func(code int, body []byte) error
+
+	// Sampler decides if each report is sent - default is nil (send them
+	// all)
+	Sampler Sampler
+
+	// LocalSink receives every report as a json line, eg: os.Stderr to
+	// see reports in development, on top of posting them - set NoPost
+	// too to only write them to the LocalSink
+	LocalSink io.Writer
+	sinkLock  sync.Mutex
+
+	// Encoder serializes each panic report before it is POSTed, eg: for
+	// collectors expecting different field names - default is
+	// json.Marshal
+	Encoder func(dj DeferJSON) ([]byte, error)
+
+	// AllowEmptyToken POSTs even w/o a Token, eg: to a collector that
+	// doesn't need one - by default an empty Token disables posting w/a
+	// single warning
+	AllowEmptyToken bool
+	emptyTokenOnce  sync.Once
+
+	// suppressUntil && suppressedCount are the deadline && the count of
+	// the reports dropped by Suppress/SuppressUntil
+	suppressUntil   time.Time
+	suppressedCount uint64
+
+	// EnableRemoteCommands runs the trace/profile commands sent by the
+	// api in response to stats, when false Postit skips the command
+	// dispatch entirely, eg: for security teams not wanting the app to
+	// run server-sent commands - default is false
+	// clients used to run the commands by default, set it to keep remote
+	// profiling working
+	EnableRemoteCommands bool
+
+	// CrashFile is the file PersistRepanic appends each report to, as a
+	// json line, before reissuing the panic, eg: to keep a trace of fatal
+	// crashes while the network is down - default is "" (no crash file)
+	CrashFile string
+
+	// MaxResponseBytes caps the bytes read of each api response, eg: to
+	// not run out of memory w/a broken collector - default is 1MB
+	MaxResponseBytes int64
+
+	// commandHandlers run the custom commands, see
+	// RegisterCommandHandler
+	commandHandlers map[CommandType]func(cmd Command, agent *Agent) error
+
+	// MemProfileDiffInterval makes mem profile commands take a second
+	// heap profile this long after a first one && upload both, eg: to
+	// see the allocations growing in between - default is 0 (a single
+	// heap profile)
+	MemProfileDiffInterval time.Duration
+
+	// MinProfileInterval is the minimum time between starting two
+	// trace/profile commands, any arriving sooner are skipped - default
+	// is 0 (no cooldown)
+	MinProfileInterval time.Duration
+	lastProfile        time.Time
+
+	// BundleProfiles uploads the traces/profiles made close together in
+	// a single gzipped multipart upload, for collectors supporting it -
+	// default is false (one upload per trace/profile)
+	BundleProfiles bool
+	bundled        []bundledProfile
+
+	// BundleWindow is how long a bundle waits for more traces/profiles
+	// before it is uploaded - default is 45s
+	BundleWindow time.Duration
+	bundleTimer  *time.Timer
+
+	// ProfileUploadTimeout bounds the upload of a trace/profile, slower
+	// uploads are cancelled - default is 2 minutes
+	ProfileUploadTimeout time.Duration
+
 	RunningCommands map[int]bool
 	sync.Mutex
+
+	// pending counts the reports being shipped in go routines
+	pending int
+
+	// queued are copies of the reports being shipped in go routines
+	queued map[*DeferJSON]DeferJSON
+
+	// inflight counts the duplicates of the reports being POSTed
+	inflight map[string]int
+
+	// BatchInterval buffers panic reports to POST them together every
+	// interval, or once BatchSize are buffered - default is 0 (POST each
+	// report right away)
+	BatchInterval time.Duration
+
+	// BatchSize is the number of buffered reports that triggers an early
+	// batch POST - default is 100
+	BatchSize int
+
+	batched   [][]byte
+	batchStop chan bool
+
+	// GroupWindow groups the reports w/the same backtrace, but maybe
+	// different messages, during the window into a single report w/their
+	// MessageSamples - default is 0 (no grouping)
+	GroupWindow time.Duration
+
+	// groups are the groups of reports in their window && groupTimers
+	// the timers ending them
+	groups      map[string]*DeferJSON
+	groupTimers map[string]*time.Timer
+
+	// ReportChan receives the reports in place of POSTing them, eg: to
+	// deliver them w/your own workers - reports are dropped when it is
+	// full, see ReportChanDrops - default is nil (POST them)
+	ReportChan chan<- DeferJSON
+	chanDrops  uint64
 }
 
 // DeferJSON is a struct that holds json body for POSTing to deferpanic API
 type DeferJSON struct {
-	Msg       string `json:"ErrorName"`
-	BackTrace string `json:"Body"`
-	SpanId    int64  `json:"SpanId,omitempty"`
+	Msg        string     `json:"ErrorName"`
+	BackTrace  string     `json:"Body"`
+	SpanId     int64      `json:"SpanId,omitempty"`
+	TraceId    string     `json:"TraceId,omitempty"`
+	ErrorType  string     `json:"ErrorType,omitempty"`
+	InstanceId string     `json:"InstanceId,omitempty"`
+	Resources  *Resources `json:"Resources,omitempty"`
+	GC         *GCStats   `json:"GC,omitempty"`
+	Category   string     `json:"Category,omitempty"`
+	Version    string     `json:"Version,omitempty"`
+	Revision   string     `json:"Revision,omitempty"`
+
+	// Attachments are the artifacts of the report by name, see
+	// PrepWithAttachments, they are uploaded apart from the report
+	Attachments map[string][]byte `json:"-"`
+
+	// ReportId links the report to its Attachments
+	ReportId string `json:"ReportId,omitempty"`
+
+	// RecentRequests are the http requests handled right before the
+	// report, see DeferPanicClient.RecentRequests
+	RecentRequests json.RawMessage `json:"RecentRequests,omitempty"`
+
+	// RouteParams are the path params of the request the report was
+	// raised in, see ContextWithRouteParams
+	RouteParams map[string]string `json:"RouteParams,omitempty"`
+
+	// NonFatal flags the events sent w/Note, Tags are their tags
+	NonFatal bool              `json:"NonFatal,omitempty"`
+	Tags     map[string]string `json:"Tags,omitempty"`
+
+	// Request is the http request the report was raised in, see
+	// ContextWithRequest
+	Request *RequestInfo `json:"Request,omitempty"`
+
+	// UptimeSeconds is the time since the process started, eg: to tell
+	// crashes on boot from leaks crashing after hours
+	UptimeSeconds int64 `json:"UptimeSeconds"`
+
+	// Fields are the structured fields of the request the report was
+	// raised in, see DeferPanicClient.ContextFields
+	Fields map[string]string `json:"Fields,omitempty"`
+
+	// Runtime describes the go runtime && host of the report
+	Runtime *RuntimeInfo `json:"Runtime,omitempty"`
+
+	// AllGoroutines flags a BackTrace holding the stacks of every go
+	// routine, see PrepAllGoroutines
+	AllGoroutines bool `json:"AllGoroutines,omitempty"`
+
+	// Occurrences is the number of identical reports collapsed into the
+	// last one while it was being POSTed, set on the follow-up report
+	// counting them
+	Occurrences int `json:"Occurrences,omitempty"`
+
+	// Token routes this report to another project than the client's,
+	// an empty Token falls back to the client's - it isn't POSTed in the
+	// body
+	Token string `json:"-"`
+
+	// occurredAt is when the report was raised, it keys retries of it
+	occurredAt time.Time
+
+	// sampled is set once the Sampler let the report through
+	sampled bool
+
+	// formatted flags a BackTrace already formatted by the
+	// StackFormatter, it isn't cleaned up
+	formatted bool
+
+	// MessageSamples are the distinct messages of the reports grouped
+	// into this one by GroupWindow
+	MessageSamples []string `json:"MessageSamples,omitempty"`
+
+	// Service is the service the report was raised in, eg: one of those
+	// mounted on a router
+	Service string `json:"Service,omitempty"`
+
+	// Baggage are the key/value pairs propagated along the request the
+	// report was raised in, eg: the tenant
+	Baggage map[string]string `json:"Baggage,omitempty"`
+
+	// OriginPackage is the package of the top application frame, eg: to
+	// route the report to the team owning it
+	OriginPackage string `json:"OriginPackage,omitempty"`
+
+	// OriginFunc, OriginFile && OriginLine locate the top application
+	// frame, set w/ResolveOrigin
+	OriginFunc string `json:"OriginFunc,omitempty"`
+	OriginFile string `json:"OriginFile,omitempty"`
+	OriginLine int    `json:"OriginLine,omitempty"`
+
+	// Nested flags a panic raised while earlier ones were still
+	// unwinding, eg: by a deferred function, && NestedPanics counts them
+	Nested       bool `json:"Nested,omitempty"`
+	NestedPanics int  `json:"NestedPanics,omitempty"`
+
+	// OriginalPanic locates the earliest of the nested panics, eg:
+	// main.work /app/main.go:12 - go only recovers the value of the
+	// latest one, the Msg
+	OriginalPanic string `json:"OriginalPanic,omitempty"`
 }
 
 // Response is a struct that holds list of commands to be executed and agent state at server
@@ -83,21 +419,126 @@ type Response struct {
 
 // NewDeferPanicClient instantiates and returns a new deferpanic client
 func NewDeferPanicClient(token string) *DeferPanicClient {
-	a := NewAgent()
+	return newDeferPanicClient(token, NewAgent())
+}
+
+// NewPanicOnlyClient instantiates and returns a new deferpanic client
+// that only reports panics, eg: when embedded in a library
+// it skips looking up the agent, the trace/profile commands of the api
+// needing one, so leave EnableRemoteCommands off
+func NewPanicOnlyClient(token string) *DeferPanicClient {
+	return newDeferPanicClient(token, nil)
+}
+
+// newDeferPanicClient instantiates and returns a new deferpanic client
+// w/agent a
+func newDeferPanicClient(token string, a *Agent) *DeferPanicClient {
+	version, revision := buildInfo()
 
 	dc := &DeferPanicClient{
 		Token:           token,
 		UserAgent:       "deferclient " + ApiVersion,
+		BaseURL:         ApiBase,
+		InstanceId:      instanceId,
+		BuildVersion:    version,
+		BuildRevision:   revision,
+		RuntimeInfo:     newRuntimeInfo(),
 		Agent:           a,
 		PrintPanics:     false,
 		NoPost:          false,
 		RunningCommands: make(map[int]bool),
 		HttpClient:      &http.Client{},
+
+		ProfileUploadTimeout: defaultProfileUploadTimeout,
+	}
+
+	if token == "" {
+		dc.warnEmptyToken()
+	} else if err := ValidateToken(token); err != nil {
+		log.Println(err)
 	}
 
 	return dc
 }
 
+// SetBaseURL points the client at a different api base url
+// a unix:///path/to/socket url makes the client dial that unix domain
+// socket for every request instead, eg: for a local collector sidecar
+func (c *DeferPanicClient) SetBaseURL(base string) error {
+	u, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if u.Scheme != "unix" {
+		c.BaseURL = strings.TrimRight(base, "/")
+		return nil
+	}
+
+	socket := u.Path
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+
+	// keep the client, eg: from SetHttpProxy or WithTransport, only
+	// swapping the dialer of a copy of its transport, the transport may be
+	// shared w/other clients
+	hc := c.HttpClient
+	if hc == nil || hc == defaultHttpClient {
+		hc = &http.Client{Timeout: defaultHttpClient.Timeout}
+	}
+
+	t, ok := hc.Transport.(*http.Transport)
+	if ok {
+		t = cloneTransport(t)
+	} else {
+		t = &http.Transport{}
+	}
+
+	t.DialContext = dial
+	hc.Transport = t
+
+	c.HttpClient = hc
+	c.BaseURL = "http://unix"
+
+	return nil
+}
+
+// WithTransport makes all requests of the client, panics, profiles and
+// traces alike, go through rt, eg: to dial from a specific source
+// address or w/a custom dns resolver, keeping the timeout of the client
+func (c *DeferPanicClient) WithTransport(rt http.RoundTripper) *DeferPanicClient {
+	c.Lock()
+	hc := http.Client{Timeout: defaultHttpClient.Timeout}
+	if c.HttpClient != nil && c.HttpClient != defaultHttpClient {
+		hc = *c.HttpClient
+	}
+
+	hc.Transport = rt
+	c.HttpClient = &hc
+	c.Unlock()
+
+	return c
+}
+
+// APIURL returns the url for path on the api the client posts to, eg:
+// as set by SetBaseURL
+func (c *DeferPanicClient) APIURL(path string) string {
+	c.Lock()
+	base := c.BaseURL
+	c.Unlock()
+
+	if base == "" {
+		base = ApiBase
+	}
+
+	return base + path
+}
+
 // Persist ensures any panics will post to deferpanic website for
 // tracking
 // typically used in non http go-routines
@@ -112,6 +553,20 @@ func (c *DeferPanicClient) Persist() {
 // typically used in non http go-routines
 func (c *DeferPanicClient) PersistRepanic() {
 	if err := recover(); err != nil {
+		if c.CrashFile != "" {
+			discarded := c.discarded(err)
+
+			// the crash file gets the full report, as it would be posted
+			dj := c.newDeferJSON(err, 0)
+			c.fill(dj)
+			c.writeCrashFile(dj)
+
+			if !discarded {
+				c.ship(dj, true)
+			}
+			panic(err)
+		}
+
 		c.PrepSync(err, 0)
 		panic(err)
 	}
@@ -132,32 +587,347 @@ func (c *DeferPanicClient) PrepSync(err interface{}, spanId int64) {
 	c.prep(err, spanId, true)
 }
 
+// PrepTraceId takes an error, a spanId && a string traceId, eg: a
+// 128-bit id from another tracing system
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepTraceId(err interface{}, spanId int64, traceId string) {
+	if c.discarded(err) {
+		return
+	}
+
+	dj := c.newDeferJSON(err, spanId)
+	dj.TraceId = traceId
+
+	c.ship(dj, false)
+}
+
+// PrepMsg takes a message, an error && a spanId
+// the message is used as the name of the report and the error detail is
+// kept ahead of the backtrace
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepMsg(message string, err interface{}, spanId int64) {
+	if c.discarded(err) {
+		return
+	}
+
+	dj := c.newDeferJSON(err, spanId)
+	dj.BackTrace = "panic: " + dj.Msg + "\n\n" + dj.BackTrace
+	dj.Msg = message
+
+	c.ship(dj, false)
+}
+
+// PrepWithToken takes an error, a spanId && the token of the project
+// to report to, eg: per tenant of a multi-product app
+// an empty token falls back to the client's
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepWithToken(err interface{}, spanId int64, token string) {
+	if c.discarded(err) {
+		return
+	}
+
+	dj := c.newDeferJSON(err, spanId)
+	dj.Token = token
+
+	c.ship(dj, false)
+}
+
+// PrepWithService takes an error, a spanId, a string traceId && the
+// service the error was raised in
+// an empty service is omitted
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepWithService(err interface{}, spanId int64, traceId string, service string) {
+	c.PrepContext(ContextWithSpanId(context.Background(), spanId), err, traceId, service)
+}
+
+// PrepContext takes a context, an error, a string traceId && the service
+// the error was raised in
+// the span id, the token && the baggage carried by ctx are reported
+// along, see ContextWithSpanId, ContextWithToken && ContextWithBaggage
+// an empty traceId is omitted && an empty service falls back to the
+// one carried by ctx, see ContextWithService
+func (c *DeferPanicClient) PrepContext(ctx context.Context, err interface{}, traceId string, service string) {
+	if c.discarded(err) {
+		return
+	}
+
+	dj := c.newDeferJSONContext(ctx, err)
+	dj.TraceId = traceId
+	if service != "" {
+		dj.Service = service
+	}
+
+	c.ship(dj, false)
+}
+
+// PrepNoStack takes an error && a spanId
+// it reports the error w/o capturing the backtrace, which is expensive
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepNoStack(err interface{}, spanId int64) {
+	if c.discarded(err) {
+		return
+	}
+
+	c.ship(c.newDeferJSONStack(err, spanId, false), false)
+}
+
+// PrepResult takes an error && a spanId
+// it reports the error synchronously && returns what became of the
+// report, eg: to log it locally if it wasn't sent
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepResult(err interface{}, spanId int64) ReportOutcome {
+	if outcome := c.discard(previewJSON(err)); outcome != 0 {
+		return outcome
+	}
+
+	return c.shipTrace(c.newDeferJSON(err, spanId))
+}
+
 // prep is an internal function that can be called to synchronize after
 // shipping the the trace to ensure completion.
 func (c *DeferPanicClient) prep(err interface{}, spanId int64, syncShipTrace bool) {
+	if c.discarded(err) {
+		return
+	}
+
+	c.ship(c.newDeferJSON(err, spanId), syncShipTrace)
+}
+
+// newDeferJSON cleans up the error && grabs the backtrace for a report
+func (c *DeferPanicClient) newDeferJSON(err interface{}, spanId int64) *DeferJSON {
+	return c.newDeferJSONStack(err, spanId, !c.SkipStack)
+}
+
+// newDeferJSONContext is newDeferJSON w/the span id, the token && the
+// baggage carried by ctx
+func (c *DeferPanicClient) newDeferJSONContext(ctx context.Context, err interface{}) *DeferJSON {
+	dj := c.newDeferJSON(err, SpanIdFromContext(ctx))
+	dj.Token = TokenFromContext(ctx)
+	dj.Baggage = BaggageFromContext(ctx)
+	dj.RouteParams = RouteParamsFromContext(ctx)
+	dj.Request = RequestFromContext(ctx)
+	dj.Service = ServiceFromContext(ctx)
+
+	if c.ContextFields != nil {
+		dj.Fields = c.ContextFields(ctx)
+	}
+
+	return dj
+}
+
+// errorMessage returns the message of the report of err
+func errorMessage(err interface{}) string {
 	errorMsg := fmt.Sprintf("%q", err)
 
-	errorMsg = strings.Replace(errorMsg, "\"", "", -1)
+	return strings.Replace(errorMsg, "\"", "", -1)
+}
 
-	if c.PrintPanics {
-		stack := string(debug.Stack())
-		fmt.Println(stack)
+// newDeferJSONStack cleans up the error for a report, only grabbing the
+// backtrace if stack is set
+func (c *DeferPanicClient) newDeferJSONStack(err interface{}, spanId int64, stack bool) *DeferJSON {
+	if c.printPanics() {
+		c.printStack(debug.Stack())
 	}
 
-	body := backTrace()
+	// discarded ran the Sampler before the report was made
+	dj := &DeferJSON{
+		Msg:        errorMessage(err),
+		SpanId:     spanId,
+		ErrorType:  errorType(err),
+		Category:   category(err),
+		occurredAt: time.Now(),
+		sampled:    true,
+	}
+
+	if stack {
+		dj.BackTrace = backTrace()
+	}
+
+	// go only recovers the latest of nested panics but the frames of the
+	// earlier ones are still in the backtrace
+	nested := strings.Count("\n"+dj.BackTrace, "\npanic(") - 1
+	if nested > 0 {
+		dj.Nested = true
+		dj.NestedPanics = nested
+		dj.OriginalPanic = originalPanic(dj.BackTrace)
+	}
+
+	dj.OriginPackage = originPackage(dj.BackTrace)
+
+	if c.ResolveOrigin {
+		dj.OriginFunc, dj.OriginFile, dj.OriginLine = callerOrigin(skipPackage)
+	}
+
+	if c.MaxFrames > 0 {
+		dj.BackTrace = limitFrames(dj.BackTrace, c.MaxFrames)
+	}
+
+	if stack && c.StackFormatter != nil {
+		dj.BackTrace = c.StackFormatter([]byte(dj.BackTrace))
+		dj.formatted = true
+	}
+
+	if c.RecentRequests != nil {
+		dj.RecentRequests = c.RecentRequests()
+	}
+
+	if c.GrabResources {
+		dj.Resources = &Resources{}
+		dj.Resources.Set()
+	}
 
+	if c.GrabGC {
+		dj.GC = &GCStats{}
+		dj.GC.Set()
+	}
+
+	return dj
+}
+
+// SetNoPost sets NoPost, safe to call while reports are being shipped
+func (c *DeferPanicClient) SetNoPost(noPost bool) {
+	c.Lock()
+	c.NoPost = noPost
+	c.Unlock()
+}
+
+// SetPrintPanics sets PrintPanics, safe to call while reports are being
+// shipped
+func (c *DeferPanicClient) SetPrintPanics(printPanics bool) {
+	c.Lock()
+	c.PrintPanics = printPanics
+	c.Unlock()
+}
+
+// noPost returns NoPost
+func (c *DeferPanicClient) noPost() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.NoPost
+}
+
+// printPanics returns PrintPanics
+func (c *DeferPanicClient) printPanics() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.PrintPanics
+}
+
+// printStack prints stack, truncated to PrintLimit
+func (c *DeferPanicClient) printStack(stack []byte) {
+	limit := c.PrintLimit
+	if limit <= 0 {
+		limit = defaultPrintLimit
+	}
+
+	if len(stack) > limit {
+		fmt.Printf("%s\n...%v bytes omitted...\n", stack[:limit], len(stack)-limit)
+		return
+	}
+
+	fmt.Println(string(stack))
+}
+
+// ship calls shipTrace in a go routine, optionally waiting for it to
+// complete
+func (c *DeferPanicClient) ship(dj *DeferJSON, syncShipTrace bool) {
 	if syncShipTrace {
 		done := make(chan bool)
 		go func() {
-			c.ShipTrace(body, errorMsg, spanId)
+			c.shipTrace(dj)
 			done <- true
 		}()
 		<-done
 	} else {
-		go c.ShipTrace(body, errorMsg, spanId)
+		c.queue(dj)
+		go func() {
+			defer c.dequeue(dj)
+			c.shipTrace(dj)
+		}()
 	}
 }
 
+// track adds delta to the count of reports being shipped
+func (c *DeferPanicClient) track(delta int) {
+	c.Lock()
+	c.pending += delta
+	c.Unlock()
+}
+
+// Flush waits, up to timeout, for the panics still being reported to be
+// delivered, eg: before the process exits
+// it returns false if some were not delivered in time
+func (c *DeferPanicClient) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	postingNow := false
+
+	for {
+		c.Lock()
+		pending := c.pending
+		c.Unlock()
+
+		pending += len(c.reporterQueued())
+		if pending == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		// w/o waiting for the GroupWindow && BundleWindow
+		if !postingNow {
+			postingNow = true
+			go func() {
+				c.postGroups()
+				c.postBundle()
+			}()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan bool, 1)
+	go func() {
+		c.postBatch()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		return false
+	}
+}
+
+// category classifies a recovered value as a runtime error (a bug), an
+// error or a plain message passed to panic
+func category(err interface{}) string {
+	switch err.(type) {
+	case runtime.Error:
+		return CategoryRuntime
+	case error:
+		return CategoryError
+	case string:
+		return CategoryMessage
+	default:
+		return CategoryOther
+	}
+}
+
+// errorType returns the name of the go type of a recovered value
+// it returns an empty string for nil
+func errorType(err interface{}) string {
+	if err == nil {
+		return ""
+	}
+
+	return reflect.TypeOf(err).String()
+}
+
 // cleanTrace should be fixed
 // encoding
 func cleanTrace(body string) string {
@@ -172,81 +942,237 @@ func cleanTrace(body string) string {
 // ShipTrace POSTs a DeferJSON json body to the deferpanic website
 // if spanId is zero it is ignored
 func (c *DeferPanicClient) ShipTrace(exception string, errorstr string, spanId int64) {
-	if c.NoPost {
+	c.shipTrace(&DeferJSON{
+		Msg:       errorstr,
+		BackTrace: exception,
+		SpanId:    spanId,
+	})
+}
+
+// shipTrace cleans up the backtrace of dj and POSTs it to the deferpanic
+// website
+// it returns what became of the report
+func (c *DeferPanicClient) shipTrace(dj *DeferJSON) ReportOutcome {
+	noPost := c.noPost()
+	if noPost && c.LocalSink == nil {
+		return ReportNotPosted
+	}
+
+	if c.suppressed() {
+		return ReportNotPosted
+	}
+
+	if !dj.sampled && !c.sample(*dj) {
+		return ReportSampledOut
+	}
+
+	c.fill(dj)
+
+	if c.LocalSink != nil {
+		c.sink(dj)
+	}
+
+	if noPost {
+		return ReportNotPosted
+	}
+
+	if c.ReportChan != nil {
+		return c.send(dj)
+	}
+
+	// notes have no backtrace to group them by
+	if c.GroupWindow > 0 && !dj.NonFatal {
+		return c.group(dj)
+	}
+
+	return c.post(dj)
+}
+
+// post encodes && POSTs dj, or buffers it for the next batch
+func (c *DeferPanicClient) post(dj *DeferJSON) ReportOutcome {
+	b, err := c.encode(dj)
+	if err != nil {
+		log.Println(err)
+		return ReportFailed
+	}
+
+	if c.BatchInterval > 0 {
+		c.buffer(b)
+		c.uploadAttachments(dj)
+		return ReportBuffered
+	}
+
+	// collapse identical reports fired from many go routines at once
+	key := inflightKey(dj)
+	if !c.startInflight(key) {
+		return ReportCollapsed
+	}
+
+	ctx := withPriority(ContextWithToken(context.Background(), dj.Token))
+	ctx = withIdempotencyKey(ctx, newIdempotencyKey(b, dj.occurredAt))
+	outcome := c.postit(ctx, b, c.APIURL(errorsPath), ReportPanic, false)
+	if outcome == ReportSent {
+		c.uploadAttachments(dj)
+	}
+
+	dups := c.endInflight(key)
+	if dups > 0 {
+		c.postOccurrences(ctx, dj, dups)
+	}
+
+	return outcome
+}
+
+// postOccurrences POSTs a follow-up of dj counting the dups identical
+// reports collapsed into it
+func (c *DeferPanicClient) postOccurrences(ctx context.Context, dj *DeferJSON, dups int) {
+	followUp := *dj
+	followUp.Occurrences = dups
+
+	b, err := c.encode(&followUp)
+	if err != nil {
+		log.Println(err)
 		return
 	}
 
-	body := cleanTrace(exception)
+	ctx = withIdempotencyKey(ctx, newIdempotencyKey(b, dj.occurredAt))
+	c.postit(ctx, b, c.APIURL(errorsPath), ReportPanic, false)
+}
 
-	dj := &DeferJSON{
-		Msg:       errorstr,
-		BackTrace: body,
+// fill cleans up the backtrace of dj && defaults its fields to the
+// client's
+func (c *DeferPanicClient) fill(dj *DeferJSON) {
+	if !dj.formatted {
+		dj.BackTrace = cleanTrace(dj.BackTrace)
+	}
+
+	if dj.InstanceId == "" {
+		dj.InstanceId = c.InstanceId
+	}
+
+	if dj.Version == "" {
+		dj.Version = c.BuildVersion
+	}
+
+	if dj.Revision == "" {
+		dj.Revision = c.BuildRevision
 	}
 
-	if spanId > 0 {
-		dj.SpanId = spanId
+	if dj.SpanId < 0 {
+		dj.SpanId = 0
 	}
 
+	if dj.Runtime == nil {
+		dj.Runtime = c.RuntimeInfo
+	}
+
+	if dj.occurredAt.IsZero() {
+		dj.occurredAt = time.Now()
+	}
+
+	if dj.UptimeSeconds == 0 {
+		dj.UptimeSeconds = int64(dj.occurredAt.Sub(processStart).Seconds())
+	}
+}
+
+// sink writes dj as a json line to the LocalSink
+func (c *DeferPanicClient) sink(dj *DeferJSON) {
 	b, err := json.Marshal(dj)
 	if err != nil {
 		log.Println(err)
+		return
 	}
 
-	c.Postit(b, errorsUrl, false)
+	c.sinkLock.Lock()
+	defer c.sinkLock.Unlock()
+
+	_, err = c.LocalSink.Write(append(b, '\n'))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// encode serializes dj w/the Encoder if one is set
+func (c *DeferPanicClient) encode(dj *DeferJSON) ([]byte, error) {
+	if c.Encoder != nil {
+		return c.Encoder(*dj)
+	}
+
+	return json.Marshal(dj)
 }
 
 // Postit Posts an API request w/b body to url and sets appropriate
 // headers
+// a Reporter is sent it as ReportOther, see PostitKind
 func (c *DeferPanicClient) Postit(b []byte, url string, analyseResponse bool) {
+	c.postit(context.Background(), b, url, ReportOther, analyseResponse)
+}
+
+// PostitKind is Postit for the report b of kind, the kind a Reporter
+// is sent it as, eg: deferstats posting ReportStats
+func (c *DeferPanicClient) PostitKind(b []byte, url string, kind ReportKind, analyseResponse bool) {
+	c.postit(context.Background(), b, url, kind, analyseResponse)
+}
+
+// postit is PostitKind w/the request bound to ctx, it returns if b was
+// delivered
+func (c *DeferPanicClient) postit(ctx context.Context, b []byte, url string, kind ReportKind, analyseResponse bool) (outcome ReportOutcome) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			err := fmt.Sprintf("%q", rec)
 			log.Println(err)
+			outcome = ReportFailed
 		}
 	}()
 
-	if c.NoPost {
-		return
+	if c.noPost() || c.suppressed() {
+		return ReportNotPosted
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if c.token(ctx) == "" && !c.AllowEmptyToken {
+		c.warnEmptyToken()
+		return ReportNotPosted
+	}
 
-	req.Header.Set("X-deferid", c.Token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", c.UserAgent)
-	req.Header.Set("X-dpenv", c.Environment)
-	req.Header.Set("X-dpgroup", c.AppGroup)
-	req.Header.Set("X-dpagentid", c.Agent.Name)
+	if c.Reporter != nil {
+		err := c.report(ctx, b, kind)
+		if err != nil {
+			log.Println(err)
+			return ReportFailed
+		}
+		return ReportSent
+	}
 
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
+	resp, body, err := c.postitResult(ctx, b, url)
+	truncated := isErr(err, ErrResponseTooLarge)
+	if err != nil && !truncated {
 		log.Println(err)
-		return
+		return ReportFailed
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case 401:
-		log.Println("wrong or invalid API token")
-	case 429:
-		log.Println("too many requests - you are being rate limited")
-	case 503:
-		log.Println("service not available")
-	default:
+	err = c.handleStatus(resp.StatusCode, body)
+	if err != nil {
+		log.Println(err)
+		return ReportFailed
 	}
 
-	if analyseResponse {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Println(err)
-			return
+	if analyseResponse && c.EnableRemoteCommands {
+		// a cut off response can't be trusted to carry all the commands
+		if truncated {
+			log.Printf("not running commands - response over %v bytes\n", c.maxResponseBytes())
+			return ReportSent
+		}
+
+		// no commands
+		if len(bytes.TrimSpace(body)) == 0 {
+			return ReportSent
 		}
 
 		var response Response
 		err = json.Unmarshal(body, &response)
 		if err != nil {
-			log.Println(err)
-			return
+			log.Printf("not running commands - invalid response: %v\n", err)
+			return ReportSent
 		}
 
 		for _, command := range response.Commands {
@@ -254,17 +1180,243 @@ func (c *DeferPanicClient) Postit(b []byte, url string, analyseResponse bool) {
 			running := c.RunningCommands[command.Id]
 			c.Unlock()
 			if !running {
-				switch command.Type {
-				case CommandTypeTrace:
-					go c.MakeTrace(command.Id, &response.Agent)
-				case CommandTypeCPUProfile:
-					go c.MakeCPUProfile(command.Id, &response.Agent)
-				case CommandTypeMemProfile:
-					go c.MakeMemProfile(command.Id, &response.Agent)
-				default:
-					log.Printf("Unknown command %v\n", command.Type)
-				}
+				c.runCommand(command, &response.Agent)
 			}
 		}
 	}
+
+	return ReportSent
+}
+
+// handleStatus passes the status && body of a response to the
+// StatusHandler, or to statusError if none is set
+func (c *DeferPanicClient) handleStatus(code int, body []byte) error {
+	if c.StatusHandler != nil {
+		return c.StatusHandler(code, body)
+	}
+
+	return statusError(code, body)
+}
+
+// statusError returns an error for any non-2xx status
+func statusError(code int, body []byte) error {
+	switch code {
+	case 401:
+		return ErrUnauthorized
+	case 429:
+		return ErrRateLimited
+	case 503:
+		return ErrServiceUnavailable
+	}
+
+	if code < 200 || code > 299 {
+		return fmt.Errorf("report not delivered - status %v: %s", code, body)
+	}
+
+	return nil
+}
+
+// token returns the token carried by ctx, or the client's if none
+func (c *DeferPanicClient) token(ctx context.Context) string {
+	if token := TokenFromContext(ctx); token != "" {
+		return token
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.Token
+}
+
+// warnEmptyToken logs, once, that posting is disabled for lack of a token
+func (c *DeferPanicClient) warnEmptyToken() {
+	c.emptyTokenOnce.Do(func() {
+		log.Println("no deferpanic token set - not posting to deferpanic")
+	})
+}
+
+// PostitResult Posts an API request w/b body to url just like Postit but
+// synchronously returns the response and its body instead of logging,
+// eg: for asserting on what a fake collector replied in tests
+// NoPost is not consulted
+func (c *DeferPanicClient) PostitResult(b []byte, url string) (*http.Response, []byte, error) {
+	return c.postitResult(context.Background(), b, url)
+}
+
+// postitResult is PostitResult w/the request bound to ctx
+func (c *DeferPanicClient) postitResult(ctx context.Context, b []byte, url string) (*http.Response, []byte, error) {
+	// clients not built w/NewDeferPanicClient are missing these
+	c.Lock()
+	if c.HttpClient == nil {
+		c.HttpClient = defaultHttpClient
+	}
+	if c.RunningCommands == nil {
+		c.RunningCommands = make(map[int]bool)
+	}
+	c.Unlock()
+
+	err := c.limit(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.request(ctx, b, url)
+}
+
+// request POSTs b to url bound to ctx, w/o waiting on the rate limit,
+// && returns the response w/its body read
+func (c *DeferPanicClient) request(ctx context.Context, b []byte, url string) (*http.Response, []byte, error) {
+	c.Lock()
+	httpClient := c.HttpClient
+	environment := c.Environment
+	c.Unlock()
+
+	if httpClient == nil {
+		httpClient = defaultHttpClient
+	}
+
+	token := c.token(ctx)
+
+	agentName := ""
+	if c.Agent != nil {
+		agentName = c.Agent.Name
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("X-deferid", token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("X-dpenv", environment)
+	req.Header.Set("X-dpgroup", c.AppGroup)
+	req.Header.Set("X-dpagentid", agentName)
+	if c.InstanceId != "" {
+		req.Header.Set("X-dpinstance", c.InstanceId)
+	}
+	for k, v := range headersFromContext(ctx) {
+		req.Header[k] = v
+	}
+	if key := idempotencyKeyFromContext(ctx); key != "" {
+		req.Header.Set("X-dpidempotency", key)
+	}
+	if c.BuildVersion != "" {
+		req.Header.Set("X-dpversion", c.BuildVersion)
+	}
+	if c.BuildRevision != "" {
+		req.Header.Set("X-dprevision", c.BuildRevision)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	max := c.maxResponseBytes()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return resp, nil, err
+	}
+
+	if int64(len(body)) > max {
+		return resp, body[:max], ErrResponseTooLarge
+	}
+
+	return resp, body, nil
+}
+
+// maxResponseBytes returns MaxResponseBytes or its default
+func (c *DeferPanicClient) maxResponseBytes() int64 {
+	if c.MaxResponseBytes <= 0 {
+		return defaultMaxResponseBytes
+	}
+
+	return c.MaxResponseBytes
+}
+
+// runCommand starts executing command in a go routine
+func (c *DeferPanicClient) runCommand(command Command, agent *Agent) {
+	switch command.Type {
+	case CommandTypeTrace, CommandTypeCPUProfile, CommandTypeMemProfile:
+		if !c.profileAllowed() {
+			log.Printf("Skipping command %v - profiled less than %v ago\n",
+				command.Id, c.MinProfileInterval)
+			return
+		}
+	}
+
+	switch command.Type {
+	case CommandTypeTrace:
+		go c.MakeTrace(command.Id, agent)
+	case CommandTypeCPUProfile:
+		go c.MakeCPUProfile(command.Id, agent)
+	case CommandTypeMemProfile:
+		go c.MakeMemProfile(command.Id, agent)
+	default:
+		c.Lock()
+		handler := c.commandHandlers[command.Type]
+		c.Unlock()
+
+		if handler == nil {
+			log.Printf("Unknown command %v\n", command.Type)
+			return
+		}
+
+		go func() {
+			if err := handler(command, agent); err != nil {
+				log.Printf("command %v failed: %v\n", command.Id, err)
+			}
+		}()
+	}
+}
+
+// uploadProfile POSTs the trace/profile b of kind giving up after
+// ProfileUploadTimeout
+func (c *DeferPanicClient) uploadProfile(b []byte, kind ReportKind) {
+	if c.BundleProfiles {
+		c.bundleProfile(b, kind)
+		return
+	}
+
+	c.upload(context.Background(), b, kind)
+}
+
+// upload POSTs the upload b of kind bound to ctx, giving up after
+// ProfileUploadTimeout
+func (c *DeferPanicClient) upload(ctx context.Context, b []byte, kind ReportKind) {
+	path := kindPath(kind)
+
+	timeout := c.ProfileUploadTimeout
+	if timeout <= 0 {
+		timeout = defaultProfileUploadTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c.postit(ctx, b, c.APIURL(path), kind, false)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("upload to %v cancelled after %v\n", path, timeout)
+	}
+}
+
+// profileAllowed reports if MinProfileInterval has passed since the last
+// profiling command started and if so marks a new one as started
+func (c *DeferPanicClient) profileAllowed() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if !c.lastProfile.IsZero() && now.Sub(c.lastProfile) < c.MinProfileInterval {
+		return false
+	}
+
+	c.lastProfile = now
+	return true
 }
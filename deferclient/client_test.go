@@ -1,7 +1,21 @@
 package deferclient
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCleanTrace(t *testing.T) {
@@ -17,3 +31,727 @@ with a linebreak and a	tab
 		t.Error("not escaping line breaks and tabs")
 	}
 }
+
+type testError struct{}
+
+func (e testError) Error() string {
+	return "test error"
+}
+
+func TestErrorType(t *testing.T) {
+	if errorType(nil) != "" {
+		t.Error("not handling a nil error")
+	}
+
+	if errorType("blah") != "string" {
+		t.Error("not naming a string panic")
+	}
+
+	if errorType(testError{}) != "deferclient.testError" {
+		t.Error("not naming a custom error type")
+	}
+}
+
+func TestZeroValueClient(t *testing.T) {
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	// only pointed at the test server, the rest is left zero
+	c := &DeferPanicClient{AllowEmptyToken: true, BaseURL: ts.URL}
+	c.Prep("blah", 0)
+
+	select {
+	case body := <-resbody:
+		var dj DeferJSON
+		err := json.Unmarshal(body, &dj)
+		if err != nil || dj.Msg != "blah" {
+			t.Errorf("not posting the report, got %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("zero value client not posting")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.HttpClient == nil {
+		t.Error("not defaulting the http client")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deferclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "collector.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets not available")
+	}
+	defer l.Close()
+
+	var respath = make(chan string, 1)
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respath <- r.URL.Path
+	}))
+
+	c := NewDeferPanicClient("token")
+	shared := &http.Transport{}
+	hc := &http.Client{Timeout: time.Second, Transport: shared}
+	c.HttpClient = hc
+
+	err = c.SetBaseURL("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.HttpClient != hc || hc.Timeout != time.Second {
+		t.Error("not keeping the http client")
+	}
+
+	if hc.Transport == shared || shared.DialContext != nil {
+		t.Error("dialing the socket w/a transport shared w/other clients")
+	}
+
+	c.ShipTrace("trace", "blah", 0)
+
+	select {
+	case path := <-respath:
+		if path != errorsPath {
+			t.Errorf("posting to the wrong path %v", path)
+		}
+	default:
+		t.Error("not posting over the unix socket")
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.Encoder = func(dj DeferJSON) ([]byte, error) {
+		return []byte(`{"error_name":"` + dj.Msg + `"}`), nil
+	}
+
+	c.ShipTrace("trace", "blah", 0)
+
+	select {
+	case body := <-resbody:
+		if string(body) != `{"error_name":"blah"}` {
+			t.Errorf("not using the encoder, got %s", body)
+		}
+	default:
+		t.Error("not posting")
+	}
+}
+
+func TestPrepMsg(t *testing.T) {
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	c.PrepMsg("parsing user input", "index out of range", 0)
+
+	var dj DeferJSON
+	err := json.Unmarshal(<-resbody, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.Msg != "parsing user input" {
+		t.Error("not using the message as the error name")
+	}
+
+	if !strings.HasPrefix(dj.BackTrace, "panic: index out of range") {
+		t.Error("not keeping the error in the body")
+	}
+}
+
+func TestProfileAllowed(t *testing.T) {
+	c := NewDeferPanicClient("token")
+
+	if !c.profileAllowed() || !c.profileAllowed() {
+		t.Error("not allowing profiles w/o a cooldown")
+	}
+
+	c.MinProfileInterval = time.Hour
+	if c.profileAllowed() {
+		t.Error("not enforcing the profile cooldown")
+	}
+
+	c.lastProfile = time.Now().Add(-2 * time.Hour)
+	if !c.profileAllowed() {
+		t.Error("not allowing a profile after the cooldown")
+	}
+}
+
+func TestInstanceId(t *testing.T) {
+	var resinstance = make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resinstance <- r.Header.Get("X-dpinstance")
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	if c.InstanceId != instanceId || c.InstanceId == "" {
+		t.Error("not defaulting to the process instance id")
+	}
+
+	c.InstanceId = "web-1"
+	c.Postit([]byte("{}"), ts.URL, false)
+
+	if <-resinstance != "web-1" {
+		t.Error("not sending the instance id")
+	}
+}
+
+func TestRuntimeInfo(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	if c.RuntimeInfo == nil || c.RuntimeInfo.GoVersion != runtime.Version() {
+		t.Fatal("not capturing the runtime info")
+	}
+
+	dj := &DeferJSON{}
+	c.fill(dj)
+	if dj.Runtime != c.RuntimeInfo {
+		t.Error("not sending the runtime info")
+	}
+
+	c.RuntimeInfo = nil
+	dj = &DeferJSON{}
+	c.fill(dj)
+	if dj.Runtime != nil {
+		t.Error("sending the runtime info once disabled")
+	}
+}
+
+func TestUptime(t *testing.T) {
+	c := NewDeferPanicClient("token")
+
+	dj := &DeferJSON{occurredAt: processStart.Add(90 * time.Second)}
+	c.fill(dj)
+	if dj.UptimeSeconds != 90 {
+		t.Errorf("want 90s of uptime, got %v", dj.UptimeSeconds)
+	}
+}
+
+func TestSetNoPost(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.SetNoPost(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ShipTrace("trace", "err", 0)
+		}()
+	}
+
+	c.SetPrintPanics(false)
+	c.SetNoPost(true)
+	wg.Wait()
+
+	if c.PrepResult("err", 0) != ReportNotPosted {
+		t.Error("not honouring SetNoPost")
+	}
+}
+
+func TestLocalSink(t *testing.T) {
+	var sink bytes.Buffer
+
+	c := NewDeferPanicClient("token")
+	c.NoPost = true
+	c.LocalSink = &sink
+
+	c.ShipTrace("trace", "one", 0)
+	c.ShipTrace("trace", "two", 0)
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("not writing a line per report, got %q", sink.String())
+	}
+
+	var dj DeferJSON
+	err := json.Unmarshal([]byte(lines[1]), &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.Msg != "two" {
+		t.Error("not writing the report")
+	}
+}
+
+func TestPrepWithToken(t *testing.T) {
+	var restoken = make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		restoken <- r.Header.Get("X-deferid")
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	c.PrepWithToken("err", 0, "tenant-token")
+	if <-restoken != "tenant-token" {
+		t.Error("not posting w/the report token")
+	}
+
+	c.PrepWithToken("err", 0, "")
+	if <-restoken != "token" {
+		t.Error("not falling back to the client token")
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	var resrevision = make(chan string, 1)
+	var resbody = make(chan DeferJSON, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var dj DeferJSON
+		json.NewDecoder(r.Body).Decode(&dj)
+		resrevision <- r.Header.Get("X-dprevision")
+		resbody <- dj
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.BuildVersion = "v1.2.3"
+	c.BuildRevision = "abc123"
+	c.ShipTrace("trace", "err", 0)
+
+	if <-resrevision != "abc123" {
+		t.Error("not sending the build revision")
+	}
+
+	dj := <-resbody
+	if dj.Version != "v1.2.3" || dj.Revision != "abc123" {
+		t.Error("not tagging the report w/the build info")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad report"))
+	}))
+	defer ts.Close()
+
+	if statusError(200, nil) != nil || statusError(500, nil) == nil || statusError(400, nil) == nil {
+		t.Error("not treating non-2xx as not delivered")
+	}
+
+	var rescode = make(chan int, 1)
+
+	c := NewDeferPanicClient("token")
+	c.StatusHandler = func(code int, body []byte) error {
+		if string(body) != "bad report" {
+			t.Error("not passing the response body")
+		}
+		rescode <- code
+		return nil
+	}
+
+	c.Postit([]byte("{}"), ts.URL, false)
+
+	if <-rescode != http.StatusBadRequest {
+		t.Error("not passing the response status")
+	}
+}
+
+func TestPanicOnlyClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Commands":[{"Id":1,"Type":42}]}`))
+	}))
+	defer ts.Close()
+
+	var ran = make(chan bool, 1)
+	handler := func(cmd Command, agent *Agent) error {
+		ran <- true
+		return nil
+	}
+
+	// the command does reach a client opted in
+	c := NewDeferPanicClient("token")
+	c.EnableRemoteCommands = true
+	c.RegisterCommandHandler(42, handler)
+	c.Postit([]byte("{}"), ts.URL, true)
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not running the command on a regular client")
+	}
+
+	c = NewPanicOnlyClient("token")
+	if c.Agent != nil || c.EnableRemoteCommands {
+		t.Fatal("not building a panic only client")
+	}
+
+	c.RegisterCommandHandler(42, handler)
+	c.Postit([]byte("{}"), ts.URL, true)
+
+	select {
+	case <-ran:
+		t.Error("running a command from the api")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPostitResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+
+	resp, body, err := c.PostitResult([]byte("{}"), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Error("not returning the response status")
+	}
+
+	if string(body) != "slow down" {
+		t.Error("not returning the response body")
+	}
+
+	_, _, err = c.PostitResult([]byte("{}"), "http://127.0.0.1:0/")
+	if err == nil {
+		t.Error("not returning transport errors")
+	}
+}
+
+func TestUploadProfileTimeout(t *testing.T) {
+	stalled := make(chan bool)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer ts.Close()
+	defer close(stalled)
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.ProfileUploadTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	c.uploadProfile([]byte("{}"), ReportCPUProfile)
+
+	if time.Since(start) > 2*time.Second {
+		t.Error("not cancelling a stalled upload")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	stalled := make(chan bool)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	c.Prep("blah", 0)
+
+	if c.Flush(50 * time.Millisecond) {
+		t.Error("not timing out on undelivered panics")
+	}
+
+	close(stalled)
+
+	if !c.Flush(2 * time.Second) {
+		t.Error("not waiting for delivered panics")
+	}
+}
+
+type recordingTransport struct {
+	lock  sync.Mutex
+	paths []string
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.lock.Lock()
+	rt.paths = append(rt.paths, r.URL.Path)
+	rt.lock.Unlock()
+
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    r,
+	}, nil
+}
+
+func TestWithTransport(t *testing.T) {
+	rt := &recordingTransport{}
+
+	c := NewDeferPanicClient("token").WithTransport(rt)
+	c.BaseURL = "http://collector.internal"
+
+	c.ShipTrace("trace", "blah", 0)
+	c.MakeMemProfile(1, &Agent{})
+	c.uploadProfile([]byte("{}"), ReportCPUProfile)
+	c.uploadProfile([]byte("{}"), ReportTrace)
+
+	expected := []string{
+		"/panics/create",
+		"/uploads/memprofile/create",
+		"/uploads/cpuprofile/create",
+		"/uploads/trace/create",
+	}
+
+	if strings.Join(rt.paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("not using the transport for every upload, got %v", rt.paths)
+	}
+
+	c = NewDeferPanicClient("token")
+	c.HttpClient.Timeout = 5 * time.Second
+	if c.WithTransport(rt).HttpClient.Timeout != 5*time.Second {
+		t.Error("dropping the timeout of the client")
+	}
+
+	c = &DeferPanicClient{}
+	if c.WithTransport(rt).HttpClient.Timeout != defaultHttpClient.Timeout {
+		t.Error("not defaulting the timeout of the client")
+	}
+}
+
+func TestCategory(t *testing.T) {
+	var err interface{}
+	func() {
+		defer func() {
+			err = recover()
+		}()
+		var m map[string]int
+		m["a"] = 1
+	}()
+
+	if category(err) != CategoryRuntime {
+		t.Error("not categorizing a runtime error")
+	}
+
+	if category(testError{}) != CategoryError {
+		t.Error("not categorizing an error")
+	}
+
+	if category("validation failed") != CategoryMessage {
+		t.Error("not categorizing a message")
+	}
+
+	if category(42) != CategoryOther {
+		t.Error("not categorizing other values")
+	}
+}
+
+func TestNestedPanics(t *testing.T) {
+	c := NewDeferPanicClient("token")
+
+	var dj *DeferJSON
+	var originalLine int
+	func() {
+		defer func() {
+			dj = c.newDeferJSON(recover(), 0)
+		}()
+		defer func() {
+			panic("panic in defer")
+		}()
+		_, _, line, _ := runtime.Caller(0)
+		originalLine = line + 2
+		panic("original panic")
+	}()
+
+	if !dj.Nested || dj.NestedPanics != 1 {
+		t.Errorf("not detecting the nested panic, got %v", dj.NestedPanics)
+	}
+
+	if dj.Msg != "panic in defer" {
+		t.Errorf("not keeping the message of the nested panic, got %v", dj.Msg)
+	}
+
+	if !strings.Contains(dj.OriginalPanic, "TestNestedPanics") || !strings.HasSuffix(dj.OriginalPanic, "client_test.go:"+strconv.Itoa(originalLine)) {
+		t.Errorf("not locating the original panic, got %v", dj.OriginalPanic)
+	}
+
+	func() {
+		defer func() {
+			dj = c.newDeferJSON(recover(), 0)
+		}()
+		panic("single panic")
+	}()
+
+	if dj.Nested || dj.NestedPanics != 0 || dj.OriginalPanic != "" || dj.Msg != "single panic" {
+		t.Error("flagging a single panic as nested")
+	}
+}
+
+func TestPrintLimit(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+
+	c := NewDeferPanicClient("token")
+	c.PrintLimit = 10
+	c.printStack([]byte(strings.Repeat("x", 100)))
+
+	w.Close()
+	os.Stdout = stdout
+
+	printed, _ := ioutil.ReadAll(r)
+	if string(printed) != strings.Repeat("x", 10)+"\n...90 bytes omitted...\n" {
+		t.Errorf("not bounding the printed stack, got %q", printed)
+	}
+}
+
+func TestStackFormatter(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.StackFormatter = func(raw []byte) string {
+		return "frames:\n" + strings.Split(string(raw), "\n")[0]
+	}
+
+	dj := c.newDeferJSON("err", 0)
+	c.fill(dj)
+
+	if !strings.HasPrefix(dj.BackTrace, "frames:\ngoroutine ") {
+		t.Errorf("not formatting the stack, got %q", dj.BackTrace)
+	}
+}
+
+func TestSkipStack(t *testing.T) {
+	c := NewDeferPanicClient("token")
+
+	dj := c.newDeferJSON("err", 0)
+	if dj.BackTrace == "" {
+		t.Error("not capturing the stack by default")
+	}
+
+	c.SkipStack = true
+	dj = c.newDeferJSON("err", 0)
+	if dj.BackTrace != "" || dj.Msg != "err" {
+		t.Error("not skipping the stack")
+	}
+}
+
+func TestEmptyToken(t *testing.T) {
+	var posts = make(chan bool, 3)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts <- true
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("")
+	c.SetBaseURL(ts.URL)
+
+	c.Prep("there is no need to panic", 0)
+	c.Postit([]byte("{}"), ts.URL, false)
+	if !c.Flush(2 * time.Second) {
+		t.Fatal("not flushing in time")
+	}
+
+	if len(posts) != 0 {
+		t.Error("posting w/o a token")
+	}
+
+	c.AllowEmptyToken = true
+	c.Postit([]byte("{}"), ts.URL, false)
+
+	select {
+	case <-posts:
+	case <-time.After(5 * time.Second):
+		t.Error("not posting w/o a token when allowed")
+	}
+}
+
+func TestPrepResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail"+errorsPath {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	if c.PrepResult("err", 0) != ReportSent {
+		t.Error("not reporting a sent report")
+	}
+
+	c.SetBaseURL(ts.URL + "/fail")
+	if c.PrepResult("err", 0) != ReportFailed {
+		t.Error("not reporting a failed report")
+	}
+
+	c.Sampler = RateSampler(0)
+	if c.PrepResult("err", 0) != ReportSampledOut {
+		t.Error("not reporting a sampled out report")
+	}
+
+	c.NoPost = true
+	if c.PrepResult("err", 0) != ReportNotPosted {
+		t.Error("not reporting an unposted report")
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Commands":[{"Id":1,"Type":4}]}`))
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.EnableRemoteCommands = true
+	c.MaxResponseBytes = 8
+
+	_, body, err := c.PostitResult([]byte("{}"), ts.URL)
+	if !isErr(err, ErrResponseTooLarge) || len(body) != 8 {
+		t.Errorf("not cutting the response off, got %q && %v", body, err)
+	}
+
+	if outcome := c.postit(context.Background(), []byte("{}"), ts.URL, ReportStats, true); outcome != ReportSent {
+		t.Errorf("failing a delivered report w/a large response, got %v", outcome)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.lastProfile.IsZero() {
+		t.Error("running the commands of a cut off response")
+	}
+}
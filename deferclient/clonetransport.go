@@ -0,0 +1,13 @@
+//go:build go1.13
+// +build go1.13
+
+package deferclient
+
+import (
+	"net/http"
+)
+
+// cloneTransport returns a copy of t
+func cloneTransport(t *http.Transport) *http.Transport {
+	return t.Clone()
+}
@@ -34,3 +34,16 @@ func NewCommand(id int, commandtype CommandType, requested bool, executed bool)
 
 	return c
 }
+
+// RegisterCommandHandler makes handler run the commands of commandType
+// sent by the api, eg: to dump the config or force a gc, in a go routine
+// the built-in trace && profile types always run their built-in handler
+func (c *DeferPanicClient) RegisterCommandHandler(commandType CommandType, handler func(cmd Command, agent *Agent) error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.commandHandlers == nil {
+		c.commandHandlers = make(map[CommandType]func(cmd Command, agent *Agent) error)
+	}
+	c.commandHandlers[commandType] = handler
+}
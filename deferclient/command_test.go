@@ -1,7 +1,10 @@
 package deferclient
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestNewCommand(t *testing.T) {
@@ -20,3 +23,54 @@ func TestNewCommand(t *testing.T) {
 		t.Error("not creating Executed field")
 	}
 }
+
+func TestEnableRemoteCommands(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Commands":[{"Id":1,"Type":4}]}`))
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.MinProfileInterval = time.Hour
+
+	c.Postit([]byte("{}"), ts.URL, true)
+
+	c.Lock()
+	ran := !c.lastProfile.IsZero()
+	c.Unlock()
+
+	if ran {
+		t.Error("running a command w/o EnableRemoteCommands")
+	}
+
+	c.EnableRemoteCommands = true
+	c.Postit([]byte("{}"), ts.URL, true)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.lastProfile.IsZero() {
+		t.Error("not running a command w/EnableRemoteCommands")
+	}
+}
+
+func TestRegisterCommandHandler(t *testing.T) {
+	ran := make(chan Command, 1)
+
+	c := NewDeferPanicClient("token")
+	c.RegisterCommandHandler(42, func(cmd Command, agent *Agent) error {
+		ran <- cmd
+		return nil
+	})
+
+	c.runCommand(Command{Id: 7, Type: 42}, &Agent{})
+
+	select {
+	case cmd := <-ran:
+		if cmd.Id != 7 {
+			t.Error("not passing the command to its handler")
+		}
+	case <-time.After(time.Second):
+		t.Error("not running the custom command")
+	}
+}
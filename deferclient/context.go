@@ -0,0 +1,220 @@
+package deferclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is the type of the keys deferclient stores in a context
+type contextKey int
+
+const (
+	// spanIdKey is the context key of the span id
+	spanIdKey contextKey = iota
+
+	// clientKey is the context key of the client
+	clientKey
+
+	// tokenKey is the context key of the token
+	tokenKey
+
+	// priorityKey flags POSTs of panic reports, they wait for the rate
+	// limit instead of being dropped
+	priorityKey
+
+	// idempotencyKey is the context key of the idempotency key of a POST
+	idempotencyKey
+
+	// headersKey is the context key of the headers overriding the
+	// defaults of a POST
+	headersKey
+
+	// baggageKey is the context key of the baggage
+	baggageKey
+
+	// routeParamsKey is the context key of the route params
+	routeParamsKey
+
+	// requestKey is the context key of the http request info
+	requestKey
+
+	// serviceKey is the context key of the service name
+	serviceKey
+)
+
+// ContextWithService returns a copy of ctx carrying the service the
+// code handling it belongs to, reported along w/panics
+func ContextWithService(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, serviceKey, service)
+}
+
+// ServiceFromContext returns the service carried by ctx, if any
+func ServiceFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	service, _ := ctx.Value(serviceKey).(string)
+	return service
+}
+
+// RequestInfo describes the http request a report was raised in
+type RequestInfo struct {
+	Method     string            `json:"Method"`
+	Path       string            `json:"Path"`
+	Host       string            `json:"Host,omitempty"`
+	StatusCode int               `json:"StatusCode,omitempty"`
+	Headers    map[string]string `json:"Headers,omitempty"`
+}
+
+// ContextWithRequest returns a copy of ctx carrying the info of the http
+// request being handled, reported along w/errors
+func ContextWithRequest(ctx context.Context, request *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestKey, request)
+}
+
+// RequestFromContext returns the http request info carried by ctx, if
+// any
+func RequestFromContext(ctx context.Context) *RequestInfo {
+	if ctx == nil {
+		return nil
+	}
+
+	request, _ := ctx.Value(requestKey).(*RequestInfo)
+	return request
+}
+
+// ContextWithRouteParams returns a copy of ctx carrying the path params
+// resolved by the router, eg: userID=123, reported along w/panics
+func ContextWithRouteParams(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, routeParamsKey, params)
+}
+
+// RouteParamsFromContext returns the route params carried by ctx, if any
+func RouteParamsFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+
+	params, _ := ctx.Value(routeParamsKey).(map[string]string)
+	return params
+}
+
+// withHeaders returns a copy of ctx carrying headers overriding the
+// defaults of a POST, eg: its Content-Type
+func withHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, headersKey, headers)
+}
+
+// headersFromContext returns the headers carried by ctx, if any
+func headersFromContext(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(headersKey).(http.Header)
+	return headers
+}
+
+// withIdempotencyKey returns a copy of ctx carrying the idempotency key
+// of a POST
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey, key)
+}
+
+// idempotencyKeyFromContext returns the idempotency key carried by ctx
+// or "" if none
+func idempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey).(string)
+	return key
+}
+
+// withPriority returns a copy of ctx flagging a priority POST
+func withPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey, true)
+}
+
+// hasPriority returns true if ctx flags a priority POST
+func hasPriority(ctx context.Context) bool {
+	priority, _ := ctx.Value(priorityKey).(bool)
+	return priority
+}
+
+// ContextWithToken returns a copy of ctx carrying the token of the
+// project reports should goto, eg: per tenant
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// TokenFromContext returns the token carried by ctx or "" if none
+func TokenFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	token, _ := ctx.Value(tokenKey).(string)
+	return token
+}
+
+// NewContext returns a copy of ctx carrying c, eg: so deeply nested code
+// can report w/o the client being passed down
+func NewContext(ctx context.Context, c *DeferPanicClient) context.Context {
+	return context.WithValue(ctx, clientKey, c)
+}
+
+// FromContext returns the client carried by ctx, if any
+func FromContext(ctx context.Context) (*DeferPanicClient, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	c, ok := ctx.Value(clientKey).(*DeferPanicClient)
+	return c, ok && c != nil
+}
+
+// ContextWithSpanId returns a copy of ctx carrying spanId
+func ContextWithSpanId(ctx context.Context, spanId int64) context.Context {
+	return context.WithValue(ctx, spanIdKey, spanId)
+}
+
+// SpanIdFromContext returns the span id carried by ctx or zero if none
+func SpanIdFromContext(ctx context.Context) int64 {
+	if ctx == nil {
+		return 0
+	}
+
+	spanId, _ := ctx.Value(spanIdKey).(int64)
+	return spanId
+}
+
+// Recover ensures any panics will post to deferpanic website for
+// tracking w/the span id, the token && the baggage carried by ctx
+// typically used as defer c.Recover(ctx) at the top of go routines
+func (c *DeferPanicClient) Recover(ctx context.Context) {
+	if err := recover(); err != nil {
+		c.PrepContext(ctx, err, "", "")
+	}
+}
+
+// RecoverAndRepanic ensures any panics will post to deferpanic website
+// for tracking w/the span id, the token && the baggage carried by ctx, it also
+// reissues the panic afterwards.
+func (c *DeferPanicClient) RecoverAndRepanic(ctx context.Context) {
+	if err := recover(); err != nil {
+		if !c.discarded(err) {
+			c.ship(c.newDeferJSONContext(ctx, err), true)
+		}
+		panic(err)
+	}
+}
+
+// Recover ensures any panics will post to deferpanic website for
+// tracking w/the client, the span id, the token && the baggage carried by ctx
+// the panic is reissued if ctx carries no client so it isn't lost
+// typically used as defer deferclient.Recover(ctx)
+func Recover(ctx context.Context) {
+	if err := recover(); err != nil {
+		c, ok := FromContext(ctx)
+		if !ok {
+			panic(err)
+		}
+
+		c.PrepContext(ctx, err, "", "")
+	}
+}
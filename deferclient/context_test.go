@@ -0,0 +1,105 @@
+package deferclient
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var resbody = make(chan []byte, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	ctx := ContextWithSpanId(context.Background(), 42)
+
+	func() {
+		defer c.Recover(ctx)
+		panic("there is no need to panic")
+	}()
+
+	var dj DeferJSON
+	err := json.Unmarshal(<-resbody, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.SpanId != 42 {
+		t.Error("not using the span id of the context")
+	}
+
+	var repanicked interface{}
+	func() {
+		defer func() {
+			repanicked = recover()
+		}()
+		defer c.RecoverAndRepanic(context.Background())
+		panic("there is no need to panic")
+	}()
+
+	if repanicked == nil {
+		t.Error("not reissuing the panic")
+	}
+
+	<-resbody
+}
+
+func TestClientContext(t *testing.T) {
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("finding a client in an empty context")
+	}
+
+	ctx := NewContext(ContextWithSpanId(context.Background(), 7), c)
+	if fc, ok := FromContext(ctx); !ok || fc != c {
+		t.Error("not finding the client in the context")
+	}
+
+	func() {
+		defer Recover(ctx)
+		panic("there is no need to panic")
+	}()
+
+	var dj DeferJSON
+	err := json.Unmarshal(<-resbody, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.SpanId != 7 {
+		t.Error("not reporting w/the client of the context")
+	}
+
+	var repanicked interface{}
+	func() {
+		defer func() {
+			repanicked = recover()
+		}()
+		defer Recover(context.Background())
+		panic("there is no need to panic")
+	}()
+
+	if repanicked == nil {
+		t.Error("losing the panic w/o a client in the context")
+	}
+}
@@ -84,6 +84,6 @@ func (c *DeferPanicClient) MakeCPUProfile(commandId int, agent *Agent) {
 			return
 		}
 
-		c.Postit(b, cpuprofileUrl, false)
+		c.uploadProfile(b, ReportCPUProfile)
 	}
 }
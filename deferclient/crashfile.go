@@ -0,0 +1,35 @@
+package deferclient
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// writeCrashFile appends dj as a json line to the CrashFile, it is best
+// effort as the panic is reissued right after: errors are only logged
+func (c *DeferPanicClient) writeCrashFile(dj *DeferJSON) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("%q\n", rec)
+		}
+	}()
+
+	b, err := json.Marshal(dj)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	f, err := os.OpenFile(c.CrashFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		log.Println(err)
+	}
+}
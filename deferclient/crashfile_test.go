@@ -0,0 +1,120 @@
+package deferclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCrashFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewDeferPanicClient("token")
+	c.SetNoPost(true)
+	c.CrashFile = filepath.Join(dir, "crash.json")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("not reissuing the panic")
+			}
+		}()
+		defer c.PersistRepanic()
+
+		panic("fatal")
+	}()
+
+	b, err := ioutil.ReadFile(c.CrashFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dj DeferJSON
+	err = json.Unmarshal(b, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.Msg != "fatal" || dj.BackTrace == "" {
+		t.Errorf("not writing the report, got %+v", dj)
+	}
+
+	if dj.InstanceId != c.InstanceId || dj.Runtime == nil {
+		t.Errorf("not writing the full report, got %+v", dj)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from many go routines
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestCrashFileUnwritable(t *testing.T) {
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.CrashFile = filepath.Join(os.DevNull, "crash.json")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("not reissuing the panic")
+			}
+		}()
+		defer c.PersistRepanic()
+
+		panic("fatal")
+	}()
+
+	if !strings.Contains(logs.String(), c.CrashFile) {
+		t.Errorf("not logging the crash file error, got %q", logs.String())
+	}
+
+	select {
+	case body := <-resbody:
+		var dj DeferJSON
+		err := json.Unmarshal(body, &dj)
+		if err != nil || dj.Msg != "fatal" {
+			t.Errorf("not posting the report, got %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("not posting the report past the crash file")
+	}
+}
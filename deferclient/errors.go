@@ -0,0 +1,88 @@
+package deferclient
+
+import (
+	"errors"
+)
+
+// the errors returned by the error-returning methods, eg: ReportBatch,
+// check them w/errors.Is to decide whether to retry, alert or fall back
+var (
+	// ErrUnauthorized is returned when the api rejects the token
+	ErrUnauthorized = errors.New("wrong or invalid API token - rejected by the api")
+
+	// ErrRateLimited is returned when the api, or MaxPostsPerSecond,
+	// rate limits a POST
+	ErrRateLimited = errors.New("too many requests - you are being rate limited")
+
+	// ErrServiceUnavailable is returned when the api is not available
+	ErrServiceUnavailable = errors.New("service not available")
+
+	// ErrNoToken is returned when posting w/o a token
+	ErrNoToken = errors.New("no deferpanic token set")
+
+	// ErrTransport is returned when a POST didn't get a response, eg:
+	// the connection was refused or timed out
+	ErrTransport = errors.New("deferpanic api not reached")
+
+	// ErrResponseTooLarge is returned w/the response cut off at
+	// MaxResponseBytes
+	ErrResponseTooLarge = errors.New("deferpanic api response over MaxResponseBytes")
+)
+
+// wrapError is err w/more context, it unwraps to err like a fmt.Errorf
+// %w error w/o needing go 1.13
+type wrapError struct {
+	msg string
+	err error
+}
+
+func (e *wrapError) Error() string {
+	return e.err.Error() + e.msg
+}
+
+// Unwrap returns the wrapped error
+func (e *wrapError) Unwrap() error {
+	return e.err
+}
+
+// isErr reports if err, or any error it wraps, is target, like
+// errors.Is w/o needing go 1.13
+func isErr(err error, target error) bool {
+	for err != nil {
+		if err == target {
+			return true
+		}
+
+		if is, ok := err.(interface{ Is(error) bool }); ok && is.Is(target) {
+			return true
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+
+	return false
+}
+
+// transportError is an ErrTransport keeping the error of the http client
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return ErrTransport.Error() + ": " + e.err.Error()
+}
+
+// Unwrap returns the error of the http client, eg: for errors.Is against
+// context.DeadlineExceeded
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// Is reports errors.Is(e, ErrTransport)
+func (e *transportError) Is(target error) bool {
+	return target == ErrTransport
+}
@@ -0,0 +1,45 @@
+//go:build go1.13
+// +build go1.13
+
+package deferclient
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestStatusErrors(t *testing.T) {
+	for code, want := range map[int]error{
+		401: ErrUnauthorized,
+		429: ErrRateLimited,
+		503: ErrServiceUnavailable,
+	} {
+		if err := statusError(code, nil); !errors.Is(err, want) {
+			t.Errorf("status %d: want %v, got %v", code, want, err)
+		}
+	}
+
+	if !errors.Is(errRateLimited, ErrRateLimited) {
+		t.Error("MaxPostsPerSecond drops are not ErrRateLimited")
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.HttpClient = &http.Client{}
+
+	_, _, err := c.PostitResult([]byte("{}"), "http://127.0.0.1:1/")
+	if !errors.Is(err, ErrTransport) {
+		t.Errorf("want ErrTransport, got %v", err)
+	}
+}
+
+func TestReportBatchNoToken(t *testing.T) {
+	c := NewDeferPanicClient("")
+
+	err := c.ReportBatch([]DeferJSON{{Msg: "msg"}})
+	if !errors.Is(err, ErrNoToken) {
+		t.Errorf("want ErrNoToken, got %v", err)
+	}
+}
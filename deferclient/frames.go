@@ -0,0 +1,172 @@
+package deferclient
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// limitFrames keeps the top max frames of trace, never splitting one,
+// and notes how many were omitted
+func limitFrames(trace string, max int) string {
+	lines := strings.Split(strings.TrimRight(trace, "\x00\n"), "\n")
+
+	kept := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "goroutine ") {
+		kept = 1
+	}
+
+	frames := 0
+	omitted := 0
+	for i := kept; i < len(lines); i++ {
+		// a frame starts w/its function, its file:line is indented
+		if !strings.HasPrefix(lines[i], "\t") {
+			frames++
+		}
+
+		if frames > max {
+			if !strings.HasPrefix(lines[i], "\t") {
+				omitted++
+			}
+			continue
+		}
+
+		kept = i + 1
+	}
+
+	if omitted == 0 {
+		return trace
+	}
+
+	return strings.Join(lines[:kept], "\n") + fmt.Sprintf("\n...%v frames omitted...\n", omitted)
+}
+
+// originPackage returns the package of the top frame of trace that isn't
+// the runtime, net/http or deferclient itself
+func originPackage(trace string) string {
+	for _, line := range strings.Split(trace, "\n") {
+		if line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+
+		fn := strings.TrimPrefix(line, "created by ")
+		if i := strings.Index(fn, " in goroutine "); i >= 0 {
+			fn = fn[:i]
+		}
+		if i := strings.LastIndex(fn, "("); i > 0 && strings.HasSuffix(fn, ")") {
+			fn = fn[:i]
+		}
+
+		pkg := funcPackage(fn)
+		if pkg == "" || skipPackage(pkg) {
+			continue
+		}
+
+		return pkg
+	}
+
+	return ""
+}
+
+// originalPanic returns the func && file:line of the frame that raised
+// the earliest panic of trace, the one below its last panic call
+func originalPanic(trace string) string {
+	lines := strings.Split(trace, "\n")
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(lines[i], "panic(") {
+			continue
+		}
+
+		// skip the panic call && its file:line
+		if i+3 >= len(lines) {
+			return ""
+		}
+
+		fileLine := strings.TrimSpace(lines[i+3])
+		if j := strings.LastIndex(fileLine, " +0x"); j >= 0 {
+			fileLine = fileLine[:j]
+		}
+
+		fn := lines[i+2]
+		if j := strings.LastIndex(fn, "("); j > 0 {
+			fn = fn[:j]
+		}
+
+		return fn + " " + fileLine
+	}
+
+	return ""
+}
+
+// funcPackage returns the package of the function fn, eg: net/http for
+// net/http.(*conn).serve, gopkg.in/yaml.v2 for gopkg.in/yaml.v2.Marshal,
+// or "" for builtins like panic
+func funcPackage(fn string) string {
+	// the linker escapes the dots of the last element of the path
+	fn = strings.Replace(fn, "%2e", ".", -1)
+
+	slash := strings.LastIndex(fn, "/")
+	dot := strings.Index(fn[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+
+	end := slash + 1 + dot
+	for v := versionSuffix(fn[end+1:]); v > 0; v = versionSuffix(fn[end+1:]) {
+		end += 1 + v
+	}
+
+	return fn[:end]
+}
+
+// versionSuffix returns the length of the version element, eg: v2 of
+// yaml.v2, that s starts w/ if a name follows it, or 0
+func versionSuffix(s string) int {
+	if len(s) < 2 || s[0] != 'v' {
+		return 0
+	}
+
+	n := 1
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	if n == 1 || n == len(s) || s[n] != '.' {
+		return 0
+	}
+
+	return n
+}
+
+// callerOrigin returns the function, file && line of the top frame of the
+// calling goroutine whose package isn't skipped, w/o parsing the stack
+func callerOrigin(skip func(pkg string) bool) (fn string, file string, line int) {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+
+		pkg := funcPackage(frame.Function)
+		if pkg != "" && !skip(pkg) {
+			return frame.Function, frame.File, frame.Line
+		}
+
+		if !more {
+			return "", "", 0
+		}
+	}
+}
+
+// skipPackage returns true for packages that never originate a panic
+// report
+func skipPackage(pkg string) bool {
+	for _, skip := range []string{"runtime", "net/http", "github.com/betacraft/deferclient"} {
+		if pkg == skip || strings.HasPrefix(pkg, skip+"/") {
+			return true
+		}
+	}
+
+	return false
+}
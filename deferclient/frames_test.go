@@ -0,0 +1,84 @@
+package deferclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitFrames(t *testing.T) {
+	var trace = "goroutine 1 [running]:\n" +
+		"main.c()\n\t/app/main.go:3 +0x1\n" +
+		"main.b()\n\t/app/main.go:2 +0x1\n" +
+		"main.a()\n\t/app/main.go:1 +0x1\n" +
+		"created by main.main\n\t/app/main.go:9 +0x1\n"
+
+	ltrace := limitFrames(trace, 2)
+
+	if ltrace != "goroutine 1 [running]:\n"+
+		"main.c()\n\t/app/main.go:3 +0x1\n"+
+		"main.b()\n\t/app/main.go:2 +0x1\n"+
+		"...2 frames omitted...\n" {
+		t.Errorf("not limiting the frames, got %q", ltrace)
+	}
+
+	if limitFrames(trace, 10) != trace {
+		t.Error("changing a trace under the limit")
+	}
+}
+
+func TestOriginPackage(t *testing.T) {
+	var trace = "goroutine 1 [running]:\n" +
+		"runtime/debug.Stack()\n\t/go/src/runtime/debug/stack.go:24 +0x1\n" +
+		"github.com/betacraft/deferclient/deferclient.backTrace()\n\t/dc/go16_backtrace.go:12 +0x1\n" +
+		"panic({0x1, 0x2})\n\t/go/src/runtime/panic.go:770 +0x1\n" +
+		"github.com/acme/billing/invoice.(*Invoice).Total(0xc0)\n\t/app/invoice.go:3 +0x1\n" +
+		"net/http.HandlerFunc.ServeHTTP(0x1)\n\t/go/src/net/http/server.go:2 +0x1\n"
+
+	if pkg := originPackage(trace); pkg != "github.com/acme/billing/invoice" {
+		t.Errorf("not finding the origin package, got %q", pkg)
+	}
+
+	if pkg := originPackage("goroutine 1 [running]:\nmain.main()\n\t/app/main.go:3 +0x1\n"); pkg != "main" {
+		t.Errorf("not finding the main package, got %q", pkg)
+	}
+
+	trace = "goroutine 1 [running]:\n" +
+		"gopkg.in/yaml.v2.(*Decoder).Decode(0xc0, {0x1, 0x2})\n\t/app/yaml.go:3 +0x1\n"
+	if pkg := originPackage(trace); pkg != "gopkg.in/yaml.v2" {
+		t.Errorf("not keeping the version of the package, got %q", pkg)
+	}
+
+	if pkg := funcPackage("gopkg.in/yaml%2ev2.Marshal"); pkg != "gopkg.in/yaml.v2" {
+		t.Errorf("not unescaping the package, got %q", pkg)
+	}
+
+	if pkg := funcPackage("github.com/acme/v2.value.v1"); pkg != "github.com/acme/v2" {
+		t.Errorf("taking a method for a version, got %q", pkg)
+	}
+
+	if originPackage("goroutine 1 [running]:\nruntime.goexit()\n\t/go/src/runtime/asm.s:1 +0x1\n") != "" {
+		t.Error("finding an origin package in the runtime")
+	}
+}
+
+func TestCallerOrigin(t *testing.T) {
+	fn, file, line := callerOrigin(func(pkg string) bool { return pkg == "runtime" })
+	if !strings.HasSuffix(fn, ".TestCallerOrigin") || !strings.HasSuffix(file, "frames_test.go") || line == 0 {
+		t.Errorf("not resolving the caller, got %v %v:%v", fn, file, line)
+	}
+
+	fn, _, _ = callerOrigin(func(pkg string) bool { return true })
+	if fn != "" {
+		t.Errorf("not skipping every frame, got %v", fn)
+	}
+}
+
+func TestResolveOrigin(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.ResolveOrigin = true
+
+	dj := c.newDeferJSON("test", 0)
+	if dj.OriginFunc != "testing.tRunner" || dj.OriginLine == 0 {
+		t.Errorf("not resolving the origin past deferclient, got %v", dj.OriginFunc)
+	}
+}
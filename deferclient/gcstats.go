@@ -0,0 +1,60 @@
+package deferclient
+
+import (
+	"runtime"
+	"time"
+)
+
+// GCStats summarizes the recent gc pauses of this process at the time of
+// a panic, eg: to tell a panic under gc pressure
+type GCStats struct {
+	NumGC uint32 `json:"NumGC"`
+
+	// Pauses is the number of recent pauses the others are computed over,
+	// up to the 256 the runtime keeps
+	Pauses     int    `json:"Pauses"`
+	MaxPauseNs uint64 `json:"MaxPauseNs"`
+	AvgPauseNs uint64 `json:"AvgPauseNs"`
+
+	// GCsPerMinute is the frequency of the recent gcs
+	GCsPerMinute float64 `json:"GCsPerMinute"`
+}
+
+// Set grabs the recent gc pauses from the runtime
+func (g *GCStats) Set() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	g.NumGC = mem.NumGC
+
+	n := int(mem.NumGC)
+	if n > len(mem.PauseNs) {
+		n = len(mem.PauseNs)
+	}
+	g.Pauses = n
+	if n == 0 {
+		return
+	}
+
+	var sum uint64
+	oldest := mem.PauseEnd[0]
+	newest := mem.PauseEnd[0]
+	for i := 0; i < n; i++ {
+		pause := mem.PauseNs[i]
+		sum += pause
+		if pause > g.MaxPauseNs {
+			g.MaxPauseNs = pause
+		}
+
+		if end := mem.PauseEnd[i]; end < oldest {
+			oldest = end
+		} else if end > newest {
+			newest = end
+		}
+	}
+	g.AvgPauseNs = sum / uint64(n)
+
+	if span := time.Duration(newest - oldest); n > 1 && span > 0 {
+		g.GCsPerMinute = float64(n-1) / span.Minutes()
+	}
+}
@@ -0,0 +1,36 @@
+package deferclient
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGCStats(t *testing.T) {
+	runtime.GC()
+	runtime.GC()
+
+	g := &GCStats{}
+	g.Set()
+
+	if g.NumGC < 2 || g.Pauses < 2 {
+		t.Errorf("not grabbing the gc pauses, got %+v", g)
+	}
+
+	if g.MaxPauseNs < g.AvgPauseNs {
+		t.Error("max pause under the average")
+	}
+
+	if g.GCsPerMinute <= 0 {
+		t.Error("not computing the gc frequency")
+	}
+}
+
+func TestGrabGC(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.GrabGC = true
+
+	dj := c.newDeferJSON("test", 0)
+	if dj.GC == nil {
+		t.Error("not grabbing the gc stats w/GrabGC")
+	}
+}
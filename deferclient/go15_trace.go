@@ -65,6 +65,6 @@ func (c *DeferPanicClient) MakeTrace(commandId int, agent *Agent) {
 			return
 		}
 
-		c.Postit(b, traceUrl, false)
+		c.uploadProfile(b, ReportTrace)
 	}
 }
@@ -0,0 +1,98 @@
+package deferclient
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"time"
+)
+
+// maxMessageSamples caps the MessageSamples of a grouped report
+const maxMessageSamples = 10
+
+// traceAddresses matches the pointers && pc offsets in a backtrace that
+// differ between panics of the same code
+var traceAddresses = regexp.MustCompile(`0x[0-9a-f]+`)
+
+// groupKey returns the key reports w/the same backtrace share, per token
+// && service so a group is never posted under another project or service
+func groupKey(dj *DeferJSON) string {
+	trace := goroutineIds.ReplaceAllString(dj.BackTrace, "goroutine")
+	trace = traceAddresses.ReplaceAllString(trace, "0x")
+
+	h := sha1.New()
+	for _, field := range []string{dj.Token, dj.Service, trace} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// group adds dj to the group of its backtrace, the first report of a
+// group is POSTed at the end of the GroupWindow w/the distinct messages
+// of the group
+func (c *DeferPanicClient) group(dj *DeferJSON) ReportOutcome {
+	key := groupKey(dj)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.groups == nil {
+		c.groups = make(map[string]*DeferJSON)
+		c.groupTimers = make(map[string]*time.Timer)
+	}
+
+	if first, ok := c.groups[key]; ok {
+		for _, msg := range first.MessageSamples {
+			if msg == dj.Msg {
+				return ReportCollapsed
+			}
+		}
+
+		if len(first.MessageSamples) < maxMessageSamples {
+			first.MessageSamples = append(first.MessageSamples, dj.Msg)
+		}
+		return ReportCollapsed
+	}
+
+	dj.MessageSamples = []string{dj.Msg}
+	c.groups[key] = dj
+
+	// so Flush waits for the group
+	c.pending++
+
+	c.groupTimers[key] = time.AfterFunc(c.GroupWindow, func() {
+		c.Lock()
+		delete(c.groups, key)
+		delete(c.groupTimers, key)
+		c.Unlock()
+
+		c.post(dj)
+		c.track(-1)
+	})
+
+	return ReportBuffered
+}
+
+// postGroups ends the GroupWindow of the open groups right away, POSTing
+// their reports, eg: on Flush or Close
+func (c *DeferPanicClient) postGroups() {
+	var open []*DeferJSON
+
+	c.Lock()
+	for key, timer := range c.groupTimers {
+		// else the window is ending already
+		if timer.Stop() {
+			open = append(open, c.groups[key])
+			delete(c.groups, key)
+			delete(c.groupTimers, key)
+		}
+	}
+	c.Unlock()
+
+	for _, dj := range open {
+		c.post(dj)
+		c.track(-1)
+	}
+}
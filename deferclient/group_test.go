@@ -0,0 +1,152 @@
+package deferclient
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGroupKey(t *testing.T) {
+	one := "goroutine 7 [running]:\\nmain.a(0xc000012345)\\n\\t/app/main.go:3 +0x1d"
+	two := "goroutine 9 [running]:\\nmain.a(0xc000054321)\\n\\t/app/main.go:3 +0x1d"
+	other := "goroutine 7 [running]:\\nmain.b(0xc000012345)\\n\\t/app/main.go:5 +0x1d"
+
+	if groupKey(&DeferJSON{BackTrace: one}) != groupKey(&DeferJSON{BackTrace: two}) {
+		t.Error("not grouping the same backtrace")
+	}
+
+	if groupKey(&DeferJSON{BackTrace: one}) == groupKey(&DeferJSON{BackTrace: other}) {
+		t.Error("grouping different backtraces")
+	}
+
+	if groupKey(&DeferJSON{BackTrace: one}) == groupKey(&DeferJSON{BackTrace: one, Token: "other"}) {
+		t.Error("grouping the reports of different tokens")
+	}
+
+	if groupKey(&DeferJSON{BackTrace: one}) == groupKey(&DeferJSON{BackTrace: one, Service: "billing"}) {
+		t.Error("grouping the reports of different services")
+	}
+}
+
+func TestGroupWindow(t *testing.T) {
+	var resbody = make(chan []byte, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.GroupWindow = time.Hour
+
+	c.ShipTrace("trace", "user 1 not found", 0)
+	c.ShipTrace("trace", "user 2 not found", 0)
+	c.ShipTrace("trace", "user 1 not found", 0)
+
+	c.Lock()
+	groups := len(c.groups)
+	c.Unlock()
+
+	if groups != 1 {
+		t.Fatal("not grouping the reports")
+	}
+
+	c.GroupWindow = 0
+	c.ShipTrace("other trace", "ungrouped", 0)
+
+	var dj DeferJSON
+	err := json.Unmarshal(<-resbody, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.Msg != "ungrouped" {
+		t.Error("grouping w/o a GroupWindow")
+	}
+
+	c.Lock()
+	first := c.groups[groupKey(&DeferJSON{BackTrace: "trace"})]
+	c.Unlock()
+
+	if len(first.MessageSamples) != 2 || first.MessageSamples[1] != "user 2 not found" {
+		t.Errorf("not sampling the distinct messages, got %v", first.MessageSamples)
+	}
+}
+
+func TestGroupTokens(t *testing.T) {
+	var tokens = make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.Header.Get("X-deferid")
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.GroupWindow = time.Hour
+
+	c.shipTrace(&DeferJSON{BackTrace: "trace", Msg: "acme down", Token: "acme"})
+	c.shipTrace(&DeferJSON{BackTrace: "trace", Msg: "globex down", Token: "globex"})
+
+	if !c.Flush(2 * time.Second) {
+		t.Fatal("waiting for the GroupWindow to flush")
+	}
+
+	if len(tokens) != 2 {
+		t.Fatalf("merging the groups of different tokens, got %d posts", len(tokens))
+	}
+
+	got := map[string]bool{<-tokens: true, <-tokens: true}
+	if !got["acme"] || !got["globex"] {
+		t.Errorf("not posting each group under its token, got %v", got)
+	}
+}
+
+func TestFlushGroups(t *testing.T) {
+	var resbody = make(chan []byte, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.GroupWindow = time.Hour
+
+	c.ShipTrace("trace", "user 1 not found", 0)
+	c.ShipTrace("other trace", "user 2 not found", 0)
+
+	if !c.Flush(2 * time.Second) {
+		t.Fatal("waiting for the GroupWindow to flush")
+	}
+
+	if len(resbody) != 2 {
+		t.Fatalf("not posting the open groups on flush, got %d", len(resbody))
+	}
+	<-resbody
+	<-resbody
+
+	c.ShipTrace("trace", "user 3 not found", 0)
+	c.Close()
+
+	if len(resbody) != 1 || len(c.PendingReports()) != 0 {
+		t.Fatal("not posting the open groups on close")
+	}
+
+	var dj DeferJSON
+	err := json.Unmarshal(<-resbody, &dj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dj.Msg != "user 3 not found" {
+		t.Errorf("posting the wrong group, got %v", dj.Msg)
+	}
+}
@@ -0,0 +1,73 @@
+package deferclient
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// goroutineIds matches the go routine ids in a backtrace, the only part
+// that differs between identical panics on different go routines
+var goroutineIds = regexp.MustCompile(`goroutine \d+`)
+
+// inflightKey returns the key identical reports share, built from the
+// fields identifying a report only so the ones differing between
+// occurrences, eg: the uptime or the resources, are ignored
+func inflightKey(dj *DeferJSON) string {
+	h := sha1.New()
+	for _, field := range []string{
+		dj.Token,
+		goroutineIds.ReplaceAllString(dj.BackTrace, "goroutine"),
+		dj.Msg,
+		strconv.FormatInt(dj.SpanId, 10),
+		dj.Service,
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newIdempotencyKey returns the key the collector dedupes deliveries of
+// the report b raised at occurredAt on, it is the same for every retry
+func newIdempotencyKey(b []byte, occurredAt time.Time) string {
+	h := sha1.New()
+	h.Write(b)
+	h.Write([]byte(strconv.FormatInt(occurredAt.UnixNano(), 10)))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// startInflight reports if the report w/key should be POSTed, if an
+// identical one is still being POSTed it is counted as a duplicate instead
+func (c *DeferPanicClient) startInflight(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.inflight == nil {
+		c.inflight = make(map[string]int)
+	}
+
+	if dups, ok := c.inflight[key]; ok {
+		c.inflight[key] = dups + 1
+		return false
+	}
+
+	c.inflight[key] = 0
+	return true
+}
+
+// endInflight marks the report w/key as POSTed and returns the number of
+// duplicates collapsed into it
+func (c *DeferPanicClient) endInflight(key string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	dups := c.inflight[key]
+	delete(c.inflight, key)
+
+	return dups
+}
@@ -0,0 +1,121 @@
+package deferclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInflightKey(t *testing.T) {
+	a := inflightKey(&DeferJSON{BackTrace: "goroutine 5 [running]:\nmain.main()"})
+	b := inflightKey(&DeferJSON{BackTrace: "goroutine 7 [running]:\nmain.main()"})
+	c := inflightKey(&DeferJSON{BackTrace: "goroutine 7 [running]:\nmain.other()"})
+
+	if a != b {
+		t.Error("not ignoring go routine ids")
+	}
+
+	if a == c {
+		t.Error("not telling different reports apart")
+	}
+
+	d := inflightKey(&DeferJSON{BackTrace: "main.main()", UptimeSeconds: 5, GC: &GCStats{},
+		Resources: &Resources{}, RecentRequests: json.RawMessage(`[{"Path":"/a"}]`)})
+	e := inflightKey(&DeferJSON{BackTrace: "main.main()", UptimeSeconds: 6})
+	if d != e {
+		t.Error("not ignoring the fields differing between occurrences")
+	}
+
+	for _, dj := range []*DeferJSON{
+		{BackTrace: "main.main()", Token: "other"},
+		{BackTrace: "main.main()", Msg: "other"},
+		{BackTrace: "main.main()", SpanId: 1},
+		{BackTrace: "main.main()", Service: "other"},
+	} {
+		if inflightKey(dj) == e {
+			t.Errorf("not telling reports apart by %+v", dj)
+		}
+	}
+}
+
+func TestInflightDedup(t *testing.T) {
+	var lock sync.Mutex
+	posts := 0
+	var occurrences []int
+
+	arrived := make(chan bool, 2)
+	stalled := make(chan bool)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var dj DeferJSON
+		json.NewDecoder(r.Body).Decode(&dj)
+
+		lock.Lock()
+		posts++
+		occurrences = append(occurrences, dj.Occurrences)
+		lock.Unlock()
+
+		arrived <- true
+		<-stalled
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+
+	done := make(chan bool)
+	go func() {
+		c.shipTrace(&DeferJSON{Msg: "blah", BackTrace: "trace"})
+		done <- true
+	}()
+
+	<-arrived
+
+	for i := 0; i < 5; i++ {
+		c.shipTrace(&DeferJSON{Msg: "blah", BackTrace: "trace"})
+	}
+
+	close(stalled)
+	<-done
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if posts != 2 || occurrences[0] != 0 || occurrences[1] != 5 {
+		t.Errorf("not counting the collapsed reports in a follow-up, got %v", occurrences)
+	}
+
+	if len(c.inflight) != 0 {
+		t.Error("not clearing finished reports")
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	now := time.Now()
+
+	if newIdempotencyKey([]byte("{}"), now) != newIdempotencyKey([]byte("{}"), now) {
+		t.Error("not reusing the key for the same report")
+	}
+
+	if newIdempotencyKey([]byte("{}"), now) == newIdempotencyKey([]byte("{}"), now.Add(time.Second)) {
+		t.Error("reusing the key for another occurrence")
+	}
+
+	var reskey = make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reskey <- r.Header.Get("X-dpidempotency")
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.ShipTrace("trace", "err", 0)
+
+	if <-reskey == "" {
+		t.Error("not sending the idempotency key")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/pprof"
+	"time"
 )
 
 // MemProfile contains information about this client's memory profile and its producing package
@@ -17,6 +18,12 @@ type MemProfile struct {
 	Pkg       []byte `json:"Pkg,omitempty"`
 	CommandId int    `json:"CommandId"`
 	Ignored   bool   `json:"Ignored"`
+
+	// Base is the heap profile taken IntervalSeconds before Out w/
+	// MemProfileDiffInterval, eg: for go tool pprof -base to show the
+	// growth in between
+	Base            []byte `json:"Base,omitempty"`
+	IntervalSeconds int    `json:"IntervalSeconds,omitempty"`
 }
 
 // NewMemProfile instantitates and returns a new memory profile
@@ -32,6 +39,13 @@ func NewMemProfile(out []byte, pkg []byte, commandid int, ignored bool) *MemProf
 	return c
 }
 
+// heapProfile returns a heap profile of the process
+func heapProfile() []byte {
+	var buffer bytes.Buffer
+	pprof.Lookup("heap").WriteTo(&buffer, 0)
+	return buffer.Bytes()
+}
+
 // MakeMemProfile POST MemProfile binaries to the deferpanic website
 func (c *DeferPanicClient) MakeMemProfile(commandId int, agent *Agent) {
 	var buf []byte
@@ -46,6 +60,17 @@ func (c *DeferPanicClient) MakeMemProfile(commandId int, agent *Agent) {
 		c.Unlock()
 	}()
 
+	c.Lock()
+	interval := c.MemProfileDiffInterval
+	c.Unlock()
+
+	var base []byte
+	if interval > 0 {
+		log.Println("mem profile base started")
+		base = heapProfile()
+		time.Sleep(interval)
+	}
+
 	log.Println("mem profile started")
 	pprof.Lookup("heap").WriteTo(buffer, 0)
 	log.Println("mem profile finished")
@@ -68,6 +93,10 @@ func (c *DeferPanicClient) MakeMemProfile(commandId int, agent *Agent) {
 		pkg = []byte{}
 	}
 	t := NewMemProfile(out, pkg, commandId, false)
+	if base != nil {
+		t.Base = base
+		t.IntervalSeconds = int(interval.Seconds())
+	}
 
 	b, err := json.Marshal(t)
 	if err != nil {
@@ -75,5 +104,5 @@ func (c *DeferPanicClient) MakeMemProfile(commandId int, agent *Agent) {
 		return
 	}
 
-	c.Postit(b, memprofileUrl, false)
+	c.uploadProfile(b, ReportMemProfile)
 }
@@ -1,7 +1,11 @@
 package deferclient
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestNewMemProfile(t *testing.T) {
@@ -20,3 +24,25 @@ func TestNewMemProfile(t *testing.T) {
 		t.Error("not creating Ignored field")
 	}
 }
+
+func TestMemProfileDiff(t *testing.T) {
+	var resprofile = make(chan MemProfile, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mp MemProfile
+		json.NewDecoder(r.Body).Decode(&mp)
+		resprofile <- mp
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.MemProfileDiffInterval = 10 * time.Millisecond
+
+	c.MakeMemProfile(1, &Agent{})
+
+	mp := <-resprofile
+	if len(mp.Base) == 0 || len(mp.Out) == 0 {
+		t.Error("not uploading both heap profiles")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !go1.18
+// +build !go1.18
+
+package deferclient
+
+// buildInfo returns nothing as go < 1.18 doesn't embed the build info
+func buildInfo() (version string, revision string) {
+	return "", ""
+}
@@ -0,0 +1,24 @@
+package deferclient
+
+import (
+	"time"
+)
+
+// Note sends a non-fatal event w/message && tags, w/o a backtrace, eg: a
+// retry that succeeded after 3 attempts or a deprecated endpoint hit
+func (c *DeferPanicClient) Note(message string, tags map[string]string) {
+	dj := &DeferJSON{
+		Msg:        message,
+		Category:   CategoryNote,
+		NonFatal:   true,
+		Tags:       tags,
+		occurredAt: time.Now(),
+		sampled:    true,
+	}
+
+	if c.discard(*dj) != 0 {
+		return
+	}
+
+	c.ship(dj, false)
+}
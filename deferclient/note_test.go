@@ -0,0 +1,25 @@
+package deferclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNote(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	// notes are never held back in a group
+	c.GroupWindow = time.Hour
+
+	c.Note("retried 3 times", map[string]string{"endpoint": "/v1/charge"})
+
+	dj := <-reports
+	if !dj.NonFatal || dj.Category != CategoryNote || dj.Tags["endpoint"] != "/v1/charge" {
+		t.Errorf("not sending the note, got %+v", dj)
+	}
+
+	if dj.BackTrace != "" {
+		t.Error("sending a backtrace w/a note")
+	}
+}
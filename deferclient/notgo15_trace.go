@@ -3,6 +3,7 @@
 package deferclient
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 )
@@ -26,5 +27,5 @@ func (c *DeferPanicClient) MakeTrace(commandId int, agent *Agent) {
 		return
 	}
 
-	c.Postit(b, traceUrl, false)
+	c.postit(context.Background(), b, c.APIURL(tracePath), ReportTrace, false)
 }
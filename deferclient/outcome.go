@@ -0,0 +1,22 @@
+package deferclient
+
+// ReportOutcome tells what became of a report
+type ReportOutcome byte
+
+const (
+	// ReportSent is a report delivered to the api or the Reporter
+	ReportSent ReportOutcome = iota + 1
+	// ReportBuffered is a report kept to be sent later in a batch or a
+	// group
+	ReportBuffered
+	// ReportCollapsed is a report folded into an identical, or grouped,
+	// one
+	ReportCollapsed
+	// ReportSampledOut is a report dropped by the Sampler
+	ReportSampledOut
+	// ReportNotPosted is a report dropped as posting is disabled, eg:
+	// w/NoPost or w/o a token
+	ReportNotPosted
+	// ReportFailed is a report that couldn't be delivered
+	ReportFailed
+)
@@ -0,0 +1,77 @@
+package deferclient
+
+import (
+	"encoding/json"
+)
+
+// queue counts dj as being shipped && keeps a copy of it for
+// PendingReports
+func (c *DeferPanicClient) queue(dj *DeferJSON) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.queued == nil {
+		c.queued = make(map[*DeferJSON]DeferJSON)
+	}
+
+	c.queued[dj] = *dj
+	c.pending++
+}
+
+// dequeue counts dj as shipped
+func (c *DeferPanicClient) dequeue(dj *DeferJSON) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.queued, dj)
+	c.pending--
+}
+
+// PendingReports returns copies of the reports not delivered yet, being
+// shipped, buffered for the next batch, waiting for their GroupWindow
+// to end or queued by a TeeReporter, eg: to persist them on shutdown
+// batched reports serialized by a custom Encoder are left out
+func (c *DeferPanicClient) PendingReports() []DeferJSON {
+	c.Lock()
+	defer c.Unlock()
+
+	var reports []DeferJSON
+	for _, dj := range c.queued {
+		reports = append(reports, dj)
+	}
+
+	for _, dj := range c.groups {
+		reports = append(reports, *dj)
+	}
+
+	for _, b := range c.batched {
+		var dj DeferJSON
+		if json.Unmarshal(b, &dj) == nil {
+			reports = append(reports, dj)
+		}
+	}
+
+	return append(reports, queuedPanics(c.reporterQueued())...)
+}
+
+// queuedPanics returns the panic reports in queued
+func queuedPanics(queued []*teeReport) []DeferJSON {
+	var reports []DeferJSON
+
+	for _, r := range queued {
+		switch r.kind {
+		case ReportPanic:
+			var dj DeferJSON
+			if json.Unmarshal(r.body, &dj) == nil {
+				reports = append(reports, dj)
+			}
+		case ReportPanicBatch:
+			var batch []DeferJSON
+			if json.Unmarshal(r.body, &batch) == nil {
+				reports = append(reports, batch...)
+			}
+		}
+	}
+
+	return reports
+}
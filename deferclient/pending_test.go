@@ -0,0 +1,50 @@
+package deferclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPendingReports(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.BatchInterval = time.Hour
+	defer c.Close()
+
+	c.ShipTrace("trace", "batched", 0)
+
+	c.GroupWindow = time.Hour
+	c.ShipTrace("other trace", "grouped", 0)
+	c.GroupWindow = 0
+
+	block := make(chan bool)
+	defer close(block)
+
+	c.Encoder = func(dj DeferJSON) ([]byte, error) {
+		<-block
+		return json.Marshal(dj)
+	}
+	c.Prep("queued", 0)
+
+	msgs := make(map[string]bool)
+	for _, dj := range c.PendingReports() {
+		msgs[dj.Msg] = true
+	}
+
+	if !msgs["batched"] || !msgs["grouped"] || !msgs["queued"] {
+		t.Errorf("not returning every pending report, got %v", msgs)
+	}
+}
+
+// samplerFunc adapts a func to a Sampler
+type samplerFunc func(dj DeferJSON) bool
+
+func (f samplerFunc) Sample(dj DeferJSON) bool {
+	return f(dj)
+}
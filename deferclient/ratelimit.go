@@ -0,0 +1,45 @@
+package deferclient
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// errRateLimited is returned for POSTs dropped by MaxPostsPerSecond
+var errRateLimited = &wrapError{msg: " - skipping a POST over MaxPostsPerSecond", err: ErrRateLimited}
+
+// limit applies MaxPostsPerSecond to a POST bound to ctx, panic reports
+// wait for their turn while others are dropped once the limit is hit
+func (c *DeferPanicClient) limit(ctx context.Context) error {
+	c.Lock()
+	rate := c.MaxPostsPerSecond
+	if rate <= 0 {
+		c.Unlock()
+		return nil
+	}
+
+	if c.limiter == nil || c.limiter.Rate != rate {
+		c.limiter = NewTokenBucketSampler(rate, int(math.Max(1, math.Ceil(rate))))
+	}
+	limiter := c.limiter
+	c.Unlock()
+
+	if !hasPriority(ctx) {
+		if !limiter.take() {
+			return errRateLimited
+		}
+		return nil
+	}
+
+	interval := time.Duration(float64(time.Second) / rate)
+	for !limiter.take() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	return nil
+}
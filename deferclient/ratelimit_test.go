@@ -0,0 +1,32 @@
+package deferclient
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimit(t *testing.T) {
+	c := NewDeferPanicClient("token")
+
+	if c.limit(context.Background()) != nil {
+		t.Error("limiting w/o MaxPostsPerSecond")
+	}
+
+	c.MaxPostsPerSecond = 1
+
+	if c.limit(context.Background()) != nil {
+		t.Error("not allowing the first POST")
+	}
+
+	if c.limit(context.Background()) != errRateLimited {
+		t.Error("not dropping a stats POST over the limit")
+	}
+
+	ctx, cancel := context.WithTimeout(withPriority(context.Background()), 5*time.Second)
+	defer cancel()
+
+	if c.limit(ctx) != nil {
+		t.Error("not waiting for a panic POST over the limit")
+	}
+}
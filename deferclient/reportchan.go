@@ -0,0 +1,24 @@
+package deferclient
+
+// send pushes dj onto the ReportChan w/o blocking, dropping it if the
+// channel is full
+func (c *DeferPanicClient) send(dj *DeferJSON) ReportOutcome {
+	select {
+	case c.ReportChan <- *dj:
+		return ReportSent
+	default:
+		c.Lock()
+		c.chanDrops++
+		c.Unlock()
+		return ReportFailed
+	}
+}
+
+// ReportChanDrops returns the number of reports dropped as the
+// ReportChan was full
+func (c *DeferPanicClient) ReportChanDrops() uint64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.chanDrops
+}
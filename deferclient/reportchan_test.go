@@ -0,0 +1,52 @@
+package deferclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// reportingClient returns a client handing its reports to the returned
+// chan, of size 1, in place of posting them, posting anything fails t
+// done closes the api the client points at
+func reportingClient(t *testing.T) (c *DeferPanicClient, reports chan DeferJSON, done func()) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("posting to %v in place of the ReportChan", r.URL.Path)
+	}))
+
+	reports = make(chan DeferJSON, 1)
+
+	c = NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.ReportChan = reports
+
+	return c, reports, ts.Close
+}
+
+func TestReportChan(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	if outcome := c.PrepResult(errors.New("first"), 0); outcome != ReportSent {
+		t.Errorf("want ReportSent, got %v", outcome)
+	}
+
+	// dropped, not posted instead
+	if outcome := c.PrepResult(errors.New("second"), 0); outcome != ReportFailed {
+		t.Errorf("not dropping w/a full ReportChan, got %v", outcome)
+	}
+
+	if c.ReportChanDrops() != 1 {
+		t.Errorf("want 1 drop, got %d", c.ReportChanDrops())
+	}
+
+	dj := <-reports
+	if dj.Msg != "first" {
+		t.Errorf("want the first report, got %q", dj.Msg)
+	}
+
+	if len(reports) != 0 {
+		t.Error("queueing the dropped report")
+	}
+}
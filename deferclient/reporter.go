@@ -0,0 +1,145 @@
+package deferclient
+
+import (
+	"context"
+	"errors"
+)
+
+// ReportKind tells the reports a Reporter sends apart
+type ReportKind byte
+
+const (
+	// ReportPanic is a single panic report
+	ReportPanic ReportKind = iota + 1
+	// ReportPanicBatch is a json array of panic reports
+	ReportPanicBatch
+	// ReportStats is a deferstats report
+	ReportStats
+	// ReportAgent is the check in of a new agent
+	ReportAgent
+	// ReportCPUProfile is a cpu profile upload
+	ReportCPUProfile
+	// ReportMemProfile is a memory profile upload
+	ReportMemProfile
+	// ReportTrace is a trace upload
+	ReportTrace
+	// ReportOther is anything else POSTed
+	ReportOther
+	// ReportProfileBundle is a gzipped multipart upload of traces &&
+	// profiles
+	ReportProfileBundle
+	// ReportAttachments is a multipart upload of the attachments of a
+	// panic report
+	ReportAttachments
+)
+
+// Reporter sends reports in place of POSTing them to the api, eg: to a
+// NATS or kafka topic consumed by a relay, see HTTPReporter for the
+// default one
+type Reporter interface {
+	Send(body []byte, kind ReportKind) error
+}
+
+// TokenReporter is a Reporter told the project each report goes to, the
+// client's Token unless overridden per report, eg: by PrepWithToken
+// the client calls SendToken in place of Send on the Reporters
+// implementing it
+type TokenReporter interface {
+	Reporter
+	SendToken(body []byte, kind ReportKind, token string) error
+}
+
+// sendReport sends body w/r, telling it token if it is a TokenReporter
+func sendReport(r Reporter, body []byte, kind ReportKind, token string) error {
+	if tr, ok := r.(TokenReporter); ok {
+		return tr.SendToken(body, kind, token)
+	}
+
+	return r.Send(body, kind)
+}
+
+// queueingReporter is a Reporter sending reports in the background
+// after Send returned, eg: TeeReporter, Flush waits for && PendingReports
+// lists the reports it still has queued
+type queueingReporter interface {
+	Reporter
+	queuedReports() []*teeReport
+}
+
+// reporterQueued returns the reports the Reporter still has queued
+func (c *DeferPanicClient) reporterQueued() []*teeReport {
+	qr, ok := c.Reporter.(queueingReporter)
+	if !ok {
+		return nil
+	}
+
+	return qr.queuedReports()
+}
+
+// kindPaths are the api paths each kind of report is POSTed to
+var kindPaths = map[ReportKind]string{
+	ReportPanic:         errorsPath,
+	ReportPanicBatch:    panicsBatchPath,
+	ReportStats:         StatsPath,
+	ReportAgent:         AgentPath,
+	ReportCPUProfile:    cpuprofilePath,
+	ReportMemProfile:    memprofilePath,
+	ReportTrace:         tracePath,
+	ReportProfileBundle: profilesBundlePath,
+	ReportAttachments:   attachmentsPath,
+}
+
+// kindPath returns the api path reports of kind are POSTed to, or "" for
+// ReportOther
+func kindPath(kind ReportKind) string {
+	return kindPaths[kind]
+}
+
+// report sends b w/the Reporter, within the rate limit
+func (c *DeferPanicClient) report(ctx context.Context, b []byte, kind ReportKind) error {
+	err := c.limit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return sendReport(c.Reporter, b, kind, c.token(ctx))
+}
+
+// errNoKindPath is returned by HTTPReporter for ReportOther reports
+var errNoKindPath = errors.New("deferclient: no api path for the report kind")
+
+// HTTPReporter is the Reporter POSTing the reports to the api, as a
+// client w/o a Reporter does, eg: to wrap it or to tee it w/a queue
+// each kind of report goes to its api path on the base url of Client,
+// w/o running the commands sent in response
+type HTTPReporter struct {
+	Client *DeferPanicClient
+}
+
+// NewHTTPReporter returns a HTTPReporter POSTing w/c
+func NewHTTPReporter(c *DeferPanicClient) *HTTPReporter {
+	return &HTTPReporter{Client: c}
+}
+
+// Send POSTs body w/the Token of the Client
+func (r *HTTPReporter) Send(body []byte, kind ReportKind) error {
+	return r.SendToken(body, kind, "")
+}
+
+// SendToken POSTs body to the project of token, or of the Token of the
+// Client if empty
+// the client reporting it already waited on its rate limit
+func (r *HTTPReporter) SendToken(body []byte, kind ReportKind, token string) error {
+	path := kindPath(kind)
+	if path == "" {
+		return errNoKindPath
+	}
+
+	ctx := ContextWithToken(context.Background(), token)
+	resp, b, err := r.Client.request(ctx, body, r.Client.APIURL(path))
+	if err != nil {
+		return err
+	}
+
+	return r.Client.handleStatus(resp.StatusCode, b)
+}
@@ -0,0 +1,123 @@
+package deferclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingReporter records the kinds && tokens of the reports it is
+// sent
+type recordingReporter struct {
+	kinds  []ReportKind
+	tokens []string
+	sync.Mutex
+}
+
+func (r *recordingReporter) Send(body []byte, kind ReportKind) error {
+	return r.SendToken(body, kind, "")
+}
+
+func (r *recordingReporter) SendToken(body []byte, kind ReportKind, token string) error {
+	r.Lock()
+	r.kinds = append(r.kinds, kind)
+	r.tokens = append(r.tokens, token)
+	r.Unlock()
+	return nil
+}
+
+func TestReportKind(t *testing.T) {
+	reporter := &recordingReporter{}
+
+	c := NewDeferPanicClient("token")
+	c.Reporter = reporter
+
+	// the kind is the one of the call site whatever the url
+	c.PostitKind([]byte("{}"), "http://example.com/custom/stats", ReportStats, false)
+	c.Postit([]byte("{}"), c.APIURL(StatsPath), false)
+	c.uploadProfile([]byte("{}"), ReportMemProfile)
+
+	reporter.Lock()
+	defer reporter.Unlock()
+
+	expected := []ReportKind{ReportStats, ReportOther, ReportMemProfile}
+	if !reflect.DeepEqual(reporter.kinds, expected) {
+		t.Errorf("not passing the kinds of the call sites, got %v", reporter.kinds)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	reporter := &recordingReporter{}
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL("http://127.0.0.1:0")
+	c.Reporter = reporter
+
+	c.ShipTrace("trace", "err", 0)
+	err := c.ReportBatch([]DeferJSON{{Msg: "one"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reporter.Lock()
+	defer reporter.Unlock()
+
+	if len(reporter.kinds) != 2 || reporter.kinds[0] != ReportPanic || reporter.kinds[1] != ReportPanicBatch {
+		t.Errorf("not sending the reports w/the Reporter, got %v", reporter.kinds)
+	}
+}
+
+func TestReporterToken(t *testing.T) {
+	reporter := &recordingReporter{}
+
+	c := NewDeferPanicClient("token")
+	c.Reporter = reporter
+
+	c.ShipTrace("trace", "err", 0)
+	c.PrepWithToken("err", 0, "other")
+	if !c.Flush(2 * time.Second) {
+		t.Fatal("not flushing in time")
+	}
+
+	reporter.Lock()
+	defer reporter.Unlock()
+
+	if len(reporter.tokens) != 2 || reporter.tokens[0] != "token" || reporter.tokens[1] != "other" {
+		t.Errorf("not sending the tokens w/the reports, got %v", reporter.tokens)
+	}
+}
+
+func TestHTTPReporter(t *testing.T) {
+	var posts = make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts <- r.URL.Path + " " + r.Header.Get("X-deferid")
+	}))
+	defer ts.Close()
+
+	c := NewDeferPanicClient("token")
+	c.SetBaseURL(ts.URL)
+	c.Reporter = NewTeeReporter(NewHTTPReporter(c))
+
+	c.PrepWithToken("err", 0, "other")
+	c.PostitKind([]byte("{}"), ts.URL+"/ignored", ReportStats, false)
+	if !c.Flush(2 * time.Second) {
+		t.Fatal("not flushing in time")
+	}
+
+	if len(posts) != 2 {
+		t.Fatalf("not posting the reports, got %d", len(posts))
+	}
+
+	got := map[string]bool{<-posts: true, <-posts: true}
+	if !got[errorsPath+" other"] || !got[StatsPath+" token"] {
+		t.Errorf("not posting each kind to its path w/its token, got %v", got)
+	}
+
+	if NewHTTPReporter(c).Send([]byte("{}"), ReportOther) != errNoKindPath {
+		t.Error("posting a report of no known path")
+	}
+}
@@ -0,0 +1,8 @@
+package deferclient
+
+// Resources holds the resource usage of this process at the time of a
+// panic, eg: for "too many open files" && out of memory panics
+type Resources struct {
+	Fds int    `json:"Fds"`
+	RSS uint64 `json:"RSS"`
+}
@@ -0,0 +1,33 @@
+package deferclient
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Set grabs the open fd count && resident memory in bytes from /proc
+func (r *Resources) Set() {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err == nil {
+		r.Fds = len(fds)
+	}
+
+	body, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		// VmRSS:	  1234 kB
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "VmRSS:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err == nil {
+			r.RSS = kb * 1024
+		}
+	}
+}
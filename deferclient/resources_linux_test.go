@@ -0,0 +1,18 @@
+package deferclient
+
+import (
+	"testing"
+)
+
+func TestResources(t *testing.T) {
+	r := &Resources{}
+	r.Set()
+
+	if r.Fds == 0 {
+		t.Error("not grabbing the open fd count")
+	}
+
+	if r.RSS == 0 {
+		t.Error("not grabbing the resident memory")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package deferclient
+
+// Set is a stub
+func (r *Resources) Set() {
+}
@@ -0,0 +1,33 @@
+package deferclient
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// RuntimeInfo describes the go runtime && the host of a process
+type RuntimeInfo struct {
+	GoVersion string `json:"GoVersion"`
+	GOOS      string `json:"GOOS"`
+	GOARCH    string `json:"GOARCH"`
+	Hostname  string `json:"Hostname,omitempty"`
+	NumCPU    int    `json:"NumCPU"`
+}
+
+// processStart is when the process started, as close as the package
+// initialization gets to it
+var processStart = time.Now()
+
+// newRuntimeInfo returns the RuntimeInfo of this process
+func newRuntimeInfo() *RuntimeInfo {
+	host, _ := os.Hostname()
+
+	return &RuntimeInfo{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		Hostname:  host,
+		NumCPU:    runtime.NumCPU(),
+	}
+}
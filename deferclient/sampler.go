@@ -0,0 +1,190 @@
+package deferclient
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Sampler decides if a report is sent, eg: to rate limit reports or to
+// only send some of the low severity ones
+type Sampler interface {
+	Sample(dj DeferJSON) bool
+}
+
+type alwaysSample struct{}
+
+// Sample always returns true
+func (alwaysSample) Sample(dj DeferJSON) bool {
+	return true
+}
+
+// AlwaysSample sends every report
+var AlwaysSample Sampler = alwaysSample{}
+
+// RateSampler sends the given fraction of reports, between 0 && 1
+type RateSampler float64
+
+// Sample returns true for the RateSampler fraction of calls
+func (r RateSampler) Sample(dj DeferJSON) bool {
+	return rand.Float64() < float64(r)
+}
+
+// TokenBucketSampler sends up to Burst reports at once && Rate reports
+// per second after that
+type TokenBucketSampler struct {
+	Rate  float64
+	Burst int
+
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// NewTokenBucketSampler instantiates and returns a new
+// TokenBucketSampler w/a full bucket
+func NewTokenBucketSampler(rate float64, burst int) *TokenBucketSampler {
+	return &TokenBucketSampler{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Sample returns true if a token is left in the bucket
+func (s *TokenBucketSampler) Sample(dj DeferJSON) bool {
+	return s.take()
+}
+
+// take takes a token from the bucket, if one is left
+func (s *TokenBucketSampler) take() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.Rate
+	if s.tokens > float64(s.Burst) {
+		s.tokens = float64(s.Burst)
+	}
+	s.last = now
+
+	if s.tokens < 1 {
+		return false
+	}
+
+	s.tokens--
+	return true
+}
+
+// BuildSampler sends every report of a build revision during Warmup after
+// the revision is first seen, eg: to catch regressions right after a
+// deploy, and leaves the later ones to Fallback - default is
+// AlwaysSample
+type BuildSampler struct {
+	Warmup   time.Duration
+	Fallback Sampler
+
+	firstSeen map[string]time.Time
+	sync.Mutex
+}
+
+// NewBuildSampler instantiates and returns a new BuildSampler
+func NewBuildSampler(warmup time.Duration, fallback Sampler) *BuildSampler {
+	return &BuildSampler{
+		Warmup:    warmup,
+		Fallback:  fallback,
+		firstSeen: make(map[string]time.Time),
+	}
+}
+
+// Sample returns true during the warm up of the revision of dj, then
+// defers to the Fallback
+func (s *BuildSampler) Sample(dj DeferJSON) bool {
+	s.Lock()
+	if s.firstSeen == nil {
+		s.firstSeen = make(map[string]time.Time)
+	}
+
+	firstSeen, ok := s.firstSeen[dj.Revision]
+	if !ok {
+		firstSeen = time.Now()
+		s.firstSeen[dj.Revision] = firstSeen
+	}
+	s.Unlock()
+
+	if time.Since(firstSeen) < s.Warmup {
+		return true
+	}
+
+	if s.Fallback == nil {
+		return AlwaysSample.Sample(dj)
+	}
+
+	return s.Fallback.Sample(dj)
+}
+
+// adaptiveWindow is the window the AdaptiveSampler measures the report
+// rate over
+const adaptiveWindow = time.Second
+
+// AdaptiveSampler scales its sample rate down as the rate of reports goes
+// over MaxRate per second, eg: during an error storm, && back up to
+// sending every report once it subsides
+type AdaptiveSampler struct {
+	MaxRate float64
+
+	rate        float64
+	seen        int
+	windowStart time.Time
+	effective   float64
+	sync.Mutex
+}
+
+// NewAdaptiveSampler instantiates and returns a new AdaptiveSampler
+// targeting maxRate reports per second
+func NewAdaptiveSampler(maxRate float64) *AdaptiveSampler {
+	return &AdaptiveSampler{
+		MaxRate:     maxRate,
+		windowStart: time.Now(),
+		effective:   1,
+	}
+}
+
+// Sample returns true for the effective rate fraction of reports
+func (s *AdaptiveSampler) Sample(dj DeferJSON) bool {
+	return rand.Float64() < s.observe(time.Now())
+}
+
+// Rate returns the current effective sample rate, between 0 && 1
+func (s *AdaptiveSampler) Rate() float64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.effective
+}
+
+// observe counts a report at now && returns the effective sample rate,
+// the report rate is averaged over the past windows so a single burst
+// doesn't swing it
+func (s *AdaptiveSampler) observe(now time.Time) float64 {
+	s.Lock()
+	defer s.Unlock()
+
+	if elapsed := now.Sub(s.windowStart); elapsed >= adaptiveWindow {
+		windowRate := float64(s.seen) / elapsed.Seconds()
+		s.rate = (s.rate + windowRate) / 2
+
+		s.effective = 1
+		if s.rate > s.MaxRate && s.rate > 0 {
+			s.effective = s.MaxRate / s.rate
+		}
+
+		s.seen = 0
+		s.windowStart = now
+	}
+
+	s.seen++
+
+	return s.effective
+}
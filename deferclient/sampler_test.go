@@ -0,0 +1,120 @@
+package deferclient
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateSampler(t *testing.T) {
+	if !AlwaysSample.Sample(DeferJSON{}) {
+		t.Error("AlwaysSample not sampling")
+	}
+
+	if RateSampler(0).Sample(DeferJSON{}) {
+		t.Error("sampling w/a zero rate")
+	}
+
+	if !RateSampler(1).Sample(DeferJSON{}) {
+		t.Error("not sampling w/a full rate")
+	}
+}
+
+func TestTokenBucketSampler(t *testing.T) {
+	s := NewTokenBucketSampler(0, 2)
+
+	for i := 0; i < 2; i++ {
+		if !s.Sample(DeferJSON{}) {
+			t.Error("not sampling the burst")
+		}
+	}
+
+	if s.Sample(DeferJSON{}) {
+		t.Error("sampling over the burst")
+	}
+}
+
+func TestSampler(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.Sampler = RateSampler(0)
+	c.Encoder = func(dj DeferJSON) ([]byte, error) {
+		t.Error("not dropping the unsampled report")
+		return nil, nil
+	}
+
+	c.ShipTrace("trace", "err", 0)
+}
+
+func TestSampleBeforeCapture(t *testing.T) {
+	var seen DeferJSON
+
+	c := NewDeferPanicClient("token")
+	c.BuildRevision = "abc123"
+	c.Sampler = samplerFunc(func(dj DeferJSON) bool {
+		seen = dj
+		return false
+	})
+	c.RecentRequests = func() json.RawMessage {
+		t.Error("capturing a sampled out report")
+		return nil
+	}
+
+	if c.PrepResult(errors.New("boom"), 0) != ReportSampledOut {
+		t.Error("not sampling out the report")
+	}
+
+	if seen.Msg != "boom" || seen.Category != CategoryError || seen.Revision != "abc123" {
+		t.Errorf("not sampling on the report, got %+v", seen)
+	}
+}
+
+func TestBuildSamplerLiteral(t *testing.T) {
+	s := &BuildSampler{Warmup: time.Hour}
+
+	if !s.Sample(DeferJSON{Revision: "abc123"}) {
+		t.Error("not sampling a new revision")
+	}
+
+	s.Warmup = 0
+	if !s.Sample(DeferJSON{Revision: "abc123"}) {
+		t.Error("not falling back to AlwaysSample")
+	}
+}
+
+func TestBuildSampler(t *testing.T) {
+	s := NewBuildSampler(time.Hour, RateSampler(0))
+
+	if !s.Sample(DeferJSON{Revision: "abc123"}) {
+		t.Error("not sampling a new revision")
+	}
+
+	s.firstSeen["abc123"] = time.Now().Add(-2 * time.Hour)
+	if s.Sample(DeferJSON{Revision: "abc123"}) {
+		t.Error("not falling back after the warm up")
+	}
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	s := NewAdaptiveSampler(10)
+	start := s.windowStart
+
+	// a storm of 100 reports per second
+	for i := 0; i < 100; i++ {
+		s.observe(start.Add(time.Duration(i) * 10 * time.Millisecond))
+	}
+	s.observe(start.Add(time.Second))
+
+	if rate := s.Rate(); rate >= 1 || rate <= 0 {
+		t.Errorf("not scaling down during the storm, got %v", rate)
+	}
+
+	// calm
+	for i := 2; i < 10; i++ {
+		s.observe(start.Add(time.Duration(i) * time.Second))
+	}
+
+	if s.Rate() != 1 {
+		t.Errorf("not restoring full sampling, got %v", s.Rate())
+	}
+}
@@ -0,0 +1,34 @@
+package deferclient
+
+import (
+	"context"
+	"math/rand"
+	"net"
+)
+
+// BaseContext returns a http.Server BaseContext carrying c, so handlers
+// not wrapped by a deferstats middleware can still report their panics
+// w/defer deferclient.Recover(r.Context()):
+//
+//	srv := &http.Server{
+//		BaseContext: c.BaseContext(),
+//		ConnContext: c.ConnContext(),
+//	}
+func (c *DeferPanicClient) BaseContext() func(net.Listener) context.Context {
+	return func(net.Listener) context.Context {
+		return NewContext(context.Background(), c)
+	}
+}
+
+// ConnContext returns a http.Server ConnContext giving each connection
+// a span id && carrying c, if the BaseContext doesn't already, see
+// BaseContext
+func (c *DeferPanicClient) ConnContext() func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, conn net.Conn) context.Context {
+		if _, ok := FromContext(ctx); !ok {
+			ctx = NewContext(ctx, c)
+		}
+
+		return ContextWithSpanId(ctx, rand.Int63())
+	}
+}
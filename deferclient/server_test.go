@@ -0,0 +1,53 @@
+package deferclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerContext(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer Recover(r.Context())
+		panic("not wrapped")
+	}))
+	ts.Config.BaseContext = c.BaseContext()
+	ts.Config.ConnContext = c.ConnContext()
+	ts.Start()
+	defer ts.Close()
+
+	get := func(client *http.Client) DeferJSON {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		return <-reports
+	}
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.Transport.(*http.Transport).CloseIdleConnections()
+
+	dj := get(client)
+	if dj.Msg != "not wrapped" || dj.SpanId == 0 {
+		t.Errorf("not reporting w/the connection span, got %+v", dj)
+	}
+
+	// the second request reuses the connection
+	if again := get(client); again.SpanId != dj.SpanId {
+		t.Errorf("not scoping the span to the connection, got %v && %v", dj.SpanId, again.SpanId)
+	}
+
+	other := &http.Client{Transport: &http.Transport{}}
+	defer other.Transport.(*http.Transport).CloseIdleConnections()
+
+	if next := get(other); next.SpanId == dj.SpanId {
+		t.Error("sharing the span between connections")
+	}
+}
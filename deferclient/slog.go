@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package deferclient
+
+import (
+	"log/slog"
+)
+
+// SlogFields returns attrs as report fields, eg: in a ContextFields
+// extractor returning the attributes of the request scoped slog logger
+// the attrs of groups are keyed group.attr
+func SlogFields(attrs ...slog.Attr) map[string]string {
+	fields := make(map[string]string, len(attrs))
+	addSlogFields(fields, "", attrs)
+	return fields
+}
+
+// addSlogFields adds attrs to fields, their keys prefixed w/prefix
+func addSlogFields(fields map[string]string, prefix string, attrs []slog.Attr) {
+	for _, attr := range attrs {
+		v := attr.Value.Resolve()
+
+		if v.Kind() == slog.KindGroup {
+			groupPrefix := prefix
+			if attr.Key != "" {
+				groupPrefix = prefix + attr.Key + "."
+			}
+			addSlogFields(fields, groupPrefix, v.Group())
+			continue
+		}
+
+		fields[prefix+attr.Key] = v.String()
+	}
+}
@@ -0,0 +1,34 @@
+//go:build go1.21
+// +build go1.21
+
+package deferclient
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+type attrsKey struct{}
+
+func TestSlogFields(t *testing.T) {
+	fields := SlogFields(slog.String("user", "42"), slog.Group("req", slog.Int("id", 7)))
+	if len(fields) != 2 || fields["user"] != "42" || fields["req.id"] != "7" {
+		t.Errorf("not converting the attrs, got %v", fields)
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	c.ContextFields = func(ctx context.Context) map[string]string {
+		attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+		return SlogFields(attrs...)
+	}
+
+	ctx := context.WithValue(context.Background(), attrsKey{}, []slog.Attr{slog.String("user", "42")})
+
+	dj := c.newDeferJSONContext(ctx, "test")
+	if dj.Fields["user"] != "42" {
+		t.Error("not attaching the fields of the context")
+	}
+}
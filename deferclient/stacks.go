@@ -0,0 +1,51 @@
+package deferclient
+
+import (
+	"runtime"
+)
+
+// maxAllGoroutinesSize caps the size of the stacks of all go routines
+const maxAllGoroutinesSize = 1 << 20
+
+// allGoroutinesTruncated marks stacks of all go routines over the cap
+const allGoroutinesTruncated = "\n...truncated...\n"
+
+// PrepAllGoroutines takes an error && a spanId
+// it reports the error w/the stacks of every go routine instead of just
+// the current one, eg: for deadlocks && watchdog timeouts
+// if spanId is zero it is ommited
+func (c *DeferPanicClient) PrepAllGoroutines(err interface{}, spanId int64) {
+	if c.discarded(err) {
+		return
+	}
+
+	dj := c.newDeferJSONStack(err, spanId, false)
+	dj.BackTrace = allGoroutines(maxAllGoroutinesSize)
+	dj.AllGoroutines = true
+	dj.OriginPackage = originPackage(dj.BackTrace)
+
+	c.ship(dj, false)
+}
+
+// allGoroutines returns the stacks of all go routines, truncated to max
+// bytes
+func allGoroutines(max int) string {
+	size := 65536
+	for {
+		if size > max {
+			size = max
+		}
+
+		buf := make([]byte, size)
+		n := runtime.Stack(buf, true)
+		if n < size {
+			return string(buf[:n])
+		}
+
+		if size == max {
+			return string(buf[:n]) + allGoroutinesTruncated
+		}
+
+		size *= 2
+	}
+}
@@ -0,0 +1,25 @@
+package deferclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllGoroutines(t *testing.T) {
+	block := make(chan bool)
+	defer close(block)
+
+	go func() {
+		<-block
+	}()
+
+	trace := allGoroutines(maxAllGoroutinesSize)
+	if strings.Count(trace, "goroutine ") < 2 {
+		t.Error("not capturing every go routine")
+	}
+
+	trace = allGoroutines(100)
+	if !strings.HasSuffix(trace, allGoroutinesTruncated) || len(trace) != 100+len(allGoroutinesTruncated) {
+		t.Error("not capping the size")
+	}
+}
@@ -0,0 +1,120 @@
+package deferclient
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// SuppressUntil drops, but counts, the reports && stats until t, eg:
+// during a planned migration or a known outage of a third-party
+// reporting resumes by itself once t has passed
+func (c *DeferPanicClient) SuppressUntil(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !time.Now().Before(c.suppressUntil) {
+		c.suppressedCount = 0
+		log.Printf("suppressing deferpanic reporting until %v\n", t)
+	}
+
+	c.suppressUntil = t
+}
+
+// Suppress drops, but counts, the reports && stats for d, see
+// SuppressUntil
+func (c *DeferPanicClient) Suppress(d time.Duration) {
+	c.SuppressUntil(time.Now().Add(d))
+}
+
+// SuppressedReports returns the number of reports && stats dropped by the
+// current, or latest, suppression
+func (c *DeferPanicClient) SuppressedReports() uint64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.suppressedCount
+}
+
+// suppressed returns true, counting the report, while reporting is
+// suppressed && logs the resume of reporting once the deadline passed
+func (c *DeferPanicClient) suppressed() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.suppressUntil.IsZero() {
+		return false
+	}
+
+	if time.Now().Before(c.suppressUntil) {
+		c.suppressedCount++
+		return true
+	}
+
+	log.Printf("resuming deferpanic reporting - %v reports suppressed\n", c.suppressedCount)
+	c.suppressUntil = time.Time{}
+
+	return false
+}
+
+// discarded returns true if the report of err would come to nothing, see
+// discard
+func (c *DeferPanicClient) discarded(err interface{}) bool {
+	return c.discard(previewJSON(err)) != 0
+}
+
+// discard returns what becomes of the report previewed by preview if it
+// comes to nothing, eg: w/NoPost set && no PrintPanics or LocalSink or
+// dropped by the Sampler, so reports are dropped before the costly stack
+// capture && formatting - it returns 0 if the report is to be made
+// suppressed reports are counted
+func (c *DeferPanicClient) discard(preview DeferJSON) ReportOutcome {
+	c.Lock()
+	off := c.NoPost && !c.PrintPanics && c.LocalSink == nil
+	c.Unlock()
+
+	if off || c.suppressed() {
+		return ReportNotPosted
+	}
+
+	if !c.sample(preview) {
+		if c.printPanics() {
+			c.printStack(debug.Stack())
+		}
+		return ReportSampledOut
+	}
+
+	return 0
+}
+
+// previewJSON returns the fields of the report of err that are cheap to
+// make, for the Sampler to decide on before the backtrace is captured
+func previewJSON(err interface{}) DeferJSON {
+	return DeferJSON{
+		Msg:       errorMessage(err),
+		ErrorType: errorType(err),
+		Category:  category(err),
+	}
+}
+
+// sample runs the Sampler on dj w/the build && instance of the client
+// filled in, w/o the costly fill
+func (c *DeferPanicClient) sample(dj DeferJSON) bool {
+	if c.Sampler == nil {
+		return true
+	}
+
+	if dj.InstanceId == "" {
+		dj.InstanceId = c.InstanceId
+	}
+
+	if dj.Version == "" {
+		dj.Version = c.BuildVersion
+	}
+
+	if dj.Revision == "" {
+		dj.Revision = c.BuildRevision
+	}
+
+	return c.Sampler.Sample(dj)
+}
@@ -0,0 +1,57 @@
+package deferclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSuppress(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	c.Suppress(time.Hour)
+
+	if outcome := c.PrepResult(errors.New("suppressed"), 0); outcome != ReportNotPosted {
+		t.Errorf("not suppressing the report, got %v", outcome)
+	}
+
+	if len(reports) != 0 {
+		t.Error("handing over a suppressed report")
+	}
+
+	if c.SuppressedReports() != 1 {
+		t.Errorf("want 1 suppressed report, got %d", c.SuppressedReports())
+	}
+
+	c.SuppressUntil(time.Now().Add(-time.Second))
+
+	if outcome := c.PrepResult(errors.New("resumed"), 0); outcome != ReportSent {
+		t.Errorf("not resuming reporting, got %v", outcome)
+	}
+
+	if (<-reports).Msg != "resumed" {
+		t.Error("not reporting after the suppression")
+	}
+
+	if c.SuppressedReports() != 1 {
+		t.Error("not keeping the count of the last suppression")
+	}
+}
+
+func TestDiscarded(t *testing.T) {
+	c := NewDeferPanicClient("token")
+	if c.discarded("err") {
+		t.Error("discarding reports w/reporting on")
+	}
+
+	c.SetNoPost(true)
+	if !c.discarded("err") {
+		t.Error("not discarding reports w/NoPost")
+	}
+
+	c.SetPrintPanics(true)
+	if c.discarded("err") {
+		t.Error("discarding reports to print")
+	}
+}
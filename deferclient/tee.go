@@ -0,0 +1,231 @@
+package deferclient
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// teeQueueSize is the number of reports queued for each sink of a
+// TeeReporter before dropping them
+const teeQueueSize = 64
+
+var (
+	// errTeeFull is returned when every sink of a TeeReporter is full
+	errTeeFull = errors.New("deferclient: every sink of the tee is full")
+
+	// errTeeClosed is returned when the TeeReporter is closed
+	errTeeClosed = errors.New("deferclient: the tee is closed")
+)
+
+// teeSink queues the reports of a single Reporter of a TeeReporter
+type teeSink struct {
+	reporter Reporter
+	queue    chan *teeReport
+	drops    uint64
+	failures uint64
+}
+
+// teeReport is a report queued for the sinks of a TeeReporter
+type teeReport struct {
+	body  []byte
+	kind  ReportKind
+	token string
+
+	// left is the number of sinks still to send it, result gets nil once
+	// the first one sent it or the last error once all failed to
+	left     int
+	lastErr  error
+	returned bool
+	result   chan error
+}
+
+// TeeReporter sends each report to all of its Reporters, eg: to the api
+// && to a kafka topic for archival
+// each Reporter is sent the reports from its own goroutine, so a slow or
+// failing one neither blocks nor fails the others, their errors are
+// logged && the reports one can't keep up w/dropped
+// Send returns once a Reporter sent the report, the others keep sending
+// it in the background, see Flush && Close
+type TeeReporter struct {
+	sinks  []*teeSink
+	queued map[*teeReport]bool
+	closed bool
+	wg     sync.WaitGroup
+	sync.Mutex
+}
+
+// NewTeeReporter returns a TeeReporter sending each report to every
+// one of reporters
+func NewTeeReporter(reporters ...Reporter) *TeeReporter {
+	t := &TeeReporter{queued: make(map[*teeReport]bool)}
+
+	for _, reporter := range reporters {
+		s := &teeSink{reporter: reporter, queue: make(chan *teeReport, teeQueueSize)}
+		t.sinks = append(t.sinks, s)
+
+		t.wg.Add(1)
+		go t.run(s)
+	}
+
+	return t
+}
+
+// run sends the reports queued for s
+func (t *TeeReporter) run(s *teeSink) {
+	defer t.wg.Done()
+
+	for r := range s.queue {
+		err := sendReport(s.reporter, r.body, r.kind, r.token)
+		if err != nil {
+			log.Printf("tee reporter %T: %v", s.reporter, err)
+		}
+
+		t.sent(s, r, err)
+	}
+}
+
+// sent records s sending r, w/err if it failed to
+func (t *TeeReporter) sent(s *teeSink, r *teeReport, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	r.left--
+	if err != nil {
+		s.failures++
+		r.lastErr = err
+	}
+
+	if !r.returned && (err == nil || r.left == 0) {
+		r.returned = true
+		if err != nil {
+			err = fmt.Errorf("deferclient: every sink of the tee failed: %v", err)
+		}
+		r.result <- err
+	}
+
+	if r.left == 0 {
+		delete(t.queued, r)
+	}
+}
+
+// Send is SendToken w/no token, the TokenReporters falling back to
+// their own
+func (t *TeeReporter) Send(body []byte, kind ReportKind) error {
+	return t.SendToken(body, kind, "")
+}
+
+// SendToken queues body for every Reporter w/o blocking on the full
+// ones, passing token on to the TokenReporters, it returns once one of
+// them sent it
+// it errors when all of them failed to send it or were full
+func (t *TeeReporter) SendToken(body []byte, kind ReportKind, token string) error {
+	r := &teeReport{body: body, kind: kind, token: token, result: make(chan error, 1)}
+
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return errTeeClosed
+	}
+
+	for _, s := range t.sinks {
+		select {
+		case s.queue <- r:
+			r.left++
+		default:
+			s.drops++
+		}
+	}
+
+	queued := r.left
+	if queued > 0 {
+		t.queued[r] = true
+	}
+	t.Unlock()
+
+	if queued == 0 {
+		if len(t.sinks) == 0 {
+			return nil
+		}
+		return errTeeFull
+	}
+
+	return <-r.result
+}
+
+// queuedReports returns the reports Send returned for still queued for
+// some of the Reporters
+func (t *TeeReporter) queuedReports() []*teeReport {
+	t.Lock()
+	defer t.Unlock()
+
+	var reports []*teeReport
+	for r := range t.queued {
+		if r.returned {
+			reports = append(reports, r)
+		}
+	}
+
+	return reports
+}
+
+// Flush waits, up to timeout, for the queued reports to be sent by all
+// the Reporters
+// it returns false if some were not sent in time
+func (t *TeeReporter) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		t.Lock()
+		queued := len(t.queued)
+		t.Unlock()
+
+		if queued == 0 {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Close stops taking reports, it waits for the queued ones to be sent
+// && stops the goroutines of the Reporters, see Flush to bound the wait
+func (t *TeeReporter) Close() {
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return
+	}
+
+	t.closed = true
+	for _, s := range t.sinks {
+		close(s.queue)
+	}
+	t.Unlock()
+
+	t.wg.Wait()
+}
+
+// Drops returns the number of reports dropped for the i'th Reporter as
+// its queue was full
+func (t *TeeReporter) Drops(i int) uint64 {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.sinks[i].drops
+}
+
+// Failures returns the number of reports the i'th Reporter failed to
+// send
+func (t *TeeReporter) Failures(i int) uint64 {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.sinks[i].failures
+}
@@ -0,0 +1,126 @@
+package deferclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingReporter fails every report
+type failingReporter struct{}
+
+func (failingReporter) Send(body []byte, kind ReportKind) error {
+	return errors.New("down")
+}
+
+// blockedReporter blocks until unblock is closed
+type blockedReporter struct {
+	unblock chan struct{}
+}
+
+func (r blockedReporter) Send(body []byte, kind ReportKind) error {
+	<-r.unblock
+	return nil
+}
+
+func TestTeeReporter(t *testing.T) {
+	blocked := blockedReporter{unblock: make(chan struct{})}
+	recording := &recordingReporter{}
+	tee := NewTeeReporter(failingReporter{}, blocked, recording)
+
+	defer func() {
+		close(blocked.unblock)
+		tee.Close()
+	}()
+
+	for i := 0; i < teeQueueSize; i++ {
+		err := tee.SendToken([]byte("{}"), ReportPanic, "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recording.Lock()
+	n := len(recording.kinds)
+	recording.Unlock()
+
+	if n != teeQueueSize {
+		t.Errorf("blocked by a slow sink, sent %d reports", n)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tee.Failures(0) != teeQueueSize && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if tee.Failures(0) != teeQueueSize || tee.Failures(2) != 0 || tee.Drops(2) != 0 {
+		t.Errorf("not counting the failures per sink, got %d, %d", tee.Failures(0), tee.Failures(2))
+	}
+
+	if tee.Flush(50*time.Millisecond) || len(tee.queuedReports()) == 0 {
+		t.Error("not waiting for the slow sink")
+	}
+}
+
+func TestTeeReporterFailing(t *testing.T) {
+	tee := NewTeeReporter(failingReporter{}, failingReporter{})
+	defer tee.Close()
+
+	if tee.SendToken([]byte("{}"), ReportPanic, "token") == nil {
+		t.Error("not failing when every sink fails")
+	}
+}
+
+func TestTeeReporterFull(t *testing.T) {
+	blocked := blockedReporter{unblock: make(chan struct{})}
+	tee := NewTeeReporter(blocked)
+
+	errs := make(chan error, teeQueueSize+2)
+	for i := 0; i < teeQueueSize+2; i++ {
+		go func() {
+			errs <- tee.SendToken([]byte("{}"), ReportPanic, "token")
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		if err != errTeeFull || tee.Drops(0) == 0 {
+			t.Errorf("not failing when every sink is full, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("blocking on a full sink")
+	}
+
+	close(blocked.unblock)
+	tee.Close()
+
+	if !tee.Flush(0) || tee.SendToken([]byte("{}"), ReportPanic, "token") != errTeeClosed {
+		t.Error("not sending the queued reports on close")
+	}
+}
+
+func TestTeeReporterPending(t *testing.T) {
+	blocked := blockedReporter{unblock: make(chan struct{})}
+	tee := NewTeeReporter(&recordingReporter{}, blocked)
+
+	c := NewDeferPanicClient("token")
+	c.Reporter = tee
+
+	c.ShipTrace("trace", "err", 0)
+
+	if c.Flush(50 * time.Millisecond) {
+		t.Error("not waiting for the reports queued by the tee")
+	}
+
+	pending := c.PendingReports()
+	if len(pending) != 1 || pending[0].Msg != "err" {
+		t.Errorf("not listing the reports queued by the tee, got %v", pending)
+	}
+
+	close(blocked.unblock)
+
+	if !c.Flush(2 * time.Second) {
+		t.Error("not flushing the tee")
+	}
+	tee.Close()
+}
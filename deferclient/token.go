@@ -0,0 +1,136 @@
+package deferclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// minTokenLength is the length under which a token is likely truncated
+const minTokenLength = 16
+
+// ValidateToken returns an error if token looks malformed, eg: truncated
+// or w/whitespace from a copy-paste, before the api gets to reject it
+// NewDeferPanicClient logs it
+func ValidateToken(token string) error {
+	if token == "" {
+		return ErrNoToken
+	}
+
+	if strings.IndexFunc(token, unicode.IsSpace) >= 0 {
+		return errors.New("deferpanic token looks malformed - it contains whitespace")
+	}
+
+	if len(token) < minTokenLength {
+		return errors.New("deferpanic token looks malformed - it is too short, truncated?")
+	}
+
+	return nil
+}
+
+// NewDeferPanicClientFromFile instantiates and returns a new deferpanic
+// client w/the token, && optionally the environment, read from path, eg:
+// a mounted kubernetes or vault secret
+// the file holds either the token alone or token= && environment= lines
+func NewDeferPanicClientFromFile(path string) (*DeferPanicClient, error) {
+	token, environment, err := readSecret(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewDeferPanicClient(token)
+	if environment != "" {
+		c.Environment = environment
+	}
+
+	return c, nil
+}
+
+// WatchTokenFile re-reads the token && environment from path every
+// interval and swaps them in when the secret was rotated, until the
+// returned stop func is called
+func (c *DeferPanicClient) WatchTokenFile(path string, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.reloadSecret(path)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// reloadSecret swaps in the token && environment read from path
+func (c *DeferPanicClient) reloadSecret(path string) {
+	token, environment, err := readSecret(path)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	c.Lock()
+	rotated := token != c.Token
+	c.Token = token
+	if environment != "" {
+		c.Environment = environment
+	}
+	c.Unlock()
+
+	if rotated {
+		log.Println("deferpanic token rotated from " + path)
+
+		if err := ValidateToken(token); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// readSecret returns the trimmed token && the environment stored in path
+func readSecret(path string) (token string, environment string, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret := strings.TrimSpace(string(b))
+	if !strings.Contains(secret, "=") {
+		token = secret
+	}
+
+	for _, line := range strings.Split(secret, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch strings.TrimSpace(kv[0]) {
+		case "token":
+			token = strings.TrimSpace(kv[1])
+		case "environment":
+			environment = strings.TrimSpace(kv[1])
+		}
+	}
+
+	if token == "" {
+		return "", "", errors.New("no deferpanic token in " + path)
+	}
+
+	return token, environment, nil
+}
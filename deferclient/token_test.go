@@ -0,0 +1,103 @@
+package deferclient
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewDeferPanicClientFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deferclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	ioutil.WriteFile(path, []byte("sometoken\n"), 0600)
+
+	c, err := NewDeferPanicClientFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Token != "sometoken" {
+		t.Error("not reading the token from the file")
+	}
+
+	ioutil.WriteFile(path, []byte(""), 0600)
+
+	_, err = NewDeferPanicClientFromFile(path)
+	if err == nil {
+		t.Error("not rejecting an empty token file")
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	for _, token := range []string{"", "abc123", "abcdef0123456789 abcdef"} {
+		if ValidateToken(token) == nil {
+			t.Errorf("not rejecting the malformed token %q", token)
+		}
+	}
+
+	if ValidateToken("abcdef0123456789abcdef") != nil {
+		t.Error("rejecting a well formed token")
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deferclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	ioutil.WriteFile(path, []byte("token=sometoken\nenvironment=staging\n"), 0600)
+
+	c, err := NewDeferPanicClientFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Token != "sometoken" || c.Environment != "staging" {
+		t.Errorf("not reading the token && environment, got %q %q", c.Token, c.Environment)
+	}
+}
+
+func TestWatchTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deferclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	ioutil.WriteFile(path, []byte("sometoken"), 0600)
+
+	c, err := NewDeferPanicClientFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := c.WatchTokenFile(path, 10*time.Millisecond)
+	ioutil.WriteFile(path, []byte("token=rotatedtoken\nenvironment=staging"), 0600)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.token(context.Background()) != "rotatedtoken" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop()
+	stop()
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.Token != "rotatedtoken" || c.Environment != "staging" {
+		t.Errorf("not swapping in the rotated secret, got %q %q", c.Token, c.Environment)
+	}
+}
@@ -0,0 +1,71 @@
+// Package deferclienttest implements helpers to test code reporting to
+// deferpanic.
+package deferclienttest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Server is a fake deferpanic api answering w/a scripted sequence of
+// status codes, eg: 429 then 503 then 200, to drive a client through rate
+// limiting
+// set it as the base url of the client under test w/SetBaseURL(s.URL)
+type Server struct {
+	*httptest.Server
+
+	codes  []int
+	bodies [][]byte
+	sync.Mutex
+}
+
+// NewServer starts and returns a new Server answering each request w/the
+// next of codes, the last one repeats once they run out - no codes
+// answers 200
+func NewServer(codes ...int) *Server {
+	s := &Server{codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// serve answers a request w/the next scripted status code
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.Lock()
+	n := len(s.bodies)
+	s.bodies = append(s.bodies, body)
+	s.Unlock()
+
+	code := http.StatusOK
+	if len(s.codes) > 0 {
+		if n >= len(s.codes) {
+			n = len(s.codes) - 1
+		}
+		code = s.codes[n]
+	}
+
+	w.WriteHeader(code)
+}
+
+// Requests returns the number of requests received so far
+func (s *Server) Requests() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.bodies)
+}
+
+// Bodies returns the bodies of the requests received so far
+func (s *Server) Bodies() [][]byte {
+	s.Lock()
+	defer s.Unlock()
+
+	bodies := make([][]byte, len(s.bodies))
+	copy(bodies, s.bodies)
+
+	return bodies
+}
@@ -0,0 +1,28 @@
+package deferclienttest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK)
+	defer s.Close()
+
+	for _, want := range []int{429, 503, 200, 200} {
+		resp, err := http.Post(s.URL, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != want {
+			t.Errorf("not answering w/the scripted status %v, got %v", want, resp.StatusCode)
+		}
+	}
+
+	if s.Requests() != 4 || string(s.Bodies()[0]) != "{}" {
+		t.Error("not recording the requests")
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +14,8 @@ import (
 type ContextTracer struct {
 	SpanId       int64
 	ParentSpanId int64
+	TraceId      string
+	done         int32
 }
 
 func (t *ContextTracer) newId() int64 {
@@ -71,32 +73,48 @@ func (c *Client) ContextBeforeRequest(w http.ResponseWriter, r *http.Request) (
 	}
 
 	tracer = new(ContextTracer)
-	tracer.SpanId = tracer.newId()
+	if !c.DisableSpans {
+		tracer.SpanId = tracer.newId()
+	}
+
+	parentSpanHeader := c.parentSpanHeader()
 
 	// add headers
 	headers = make(map[string]string, len(r.Header))
 	for k, v := range r.Header {
-		headers[k] = strings.Join(v, ",")
+		headers[k] = c.headerValue(v)
 
 		// grab SOA tracing header if present
-		if k == "X-Dpparentspanid" {
+		if k == parentSpanHeader && !c.DisableSpans {
 			tracer.ParentSpanId, _ = strconv.ParseInt(v[0], 10, 64)
 		}
 	}
 
+	tracer.TraceId = c.traceId(r)
+
+	atomic.AddInt64(&c.root().inFlight, 1)
+
 	return startTime, ext, tracer, headers
 }
 
 // ContextAfterRequest is called after request processing in context handler
 func (c *Client) ContextAfterRequest(startTime time.Time, tracer *ContextTracer, r *http.Request,
 	headers map[string]string, status_code int, isproblem bool) {
-	appendHTTP(startTime, r.Method + " " + boneMux.GetRequestRoute(r), r.Method, status_code, tracer.SpanId,
-		tracer.ParentSpanId, isproblem, headers)
+	c.endRequest(&tracer.done)
+
+	c.appendHTTP(startTime, r, DeferHTTP{
+		StatusCode:   status_code,
+		SpanId:       tracer.SpanId,
+		ParentSpanId: tracer.ParentSpanId,
+		TraceId:      tracer.TraceId,
+		IsProblem:    isproblem,
+		Headers:      headers,
+	})
 }
 
 // GetStatsURL returns statistics submitting URL
 func (c *Client) GetStatsURL() (statsurl string) {
-	return c.statsUrl
+	return c.statsURL()
 }
 
 // SetStatsURL sets statistics submitting URL
@@ -107,6 +125,7 @@ func (c *Client) SetStatsURL(statsurl string) {
 // ResetHTTPStats clears the current list of HTTP statistics
 func ResetHTTPStats() {
 	curlist.Reset()
+	outlist.Reset()
 }
 
 // GetHTTPStats returns the current list of HTTP statistics
@@ -0,0 +1,100 @@
+package deferstats
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// drainPollInterval is how often DrainWithin checks for the http requests
+// in flight
+const drainPollInterval = 10 * time.Millisecond
+
+// DrainError is returned by DrainWithin when ctx is done before
+// everything was delivered
+type DrainError struct {
+	// Undelivered counts the http requests still in flight, the stats &&
+	// the panic reports left undelivered
+	Undelivered int
+
+	err error
+}
+
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("%v left undelivered draining deferpanic: %v", e.Undelivered, e.err)
+}
+
+// Unwrap returns the error of the context, eg: context.DeadlineExceeded
+func (e *DrainError) Unwrap() error {
+	return e.err
+}
+
+// DrainWithin waits for the http requests in flight to complete, then
+// ships the stats && the panic reports still buffered, all before ctx is
+// done - call it once http.Server.Shutdown returned:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//
+//	srv.Shutdown(ctx)
+//	if err := dps.DrainWithin(ctx); err != nil {
+//		log.Println(err)
+//	}
+//
+// it returns a *DrainError counting what was left undelivered if ctx is
+// done first
+func (c *Client) DrainWithin(ctx context.Context) error {
+	for c.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return c.drainError(ctx.Err(), int(c.InFlight())+1)
+		case <-time.After(drainPollInterval):
+		}
+	}
+
+	shipped := make(chan bool, 1)
+	go func() {
+		ds, ok := c.collect()
+		if ok {
+			c.ship(ds, false)
+		}
+		shipped <- true
+	}()
+
+	select {
+	case <-shipped:
+	case <-ctx.Done():
+		return c.drainError(ctx.Err(), 1)
+	}
+
+	timeout := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	flushed := make(chan bool, 1)
+	go func() {
+		flushed <- c.BaseClient.Flush(timeout)
+	}()
+
+	select {
+	case ok := <-flushed:
+		if !ok {
+			return c.drainError(context.DeadlineExceeded, 0)
+		}
+	case <-ctx.Done():
+		return c.drainError(ctx.Err(), 0)
+	}
+
+	return nil
+}
+
+// drainError returns a DrainError for err counting undelivered along w/
+// the panic reports still pending
+func (c *Client) drainError(err error, undelivered int) *DrainError {
+	return &DrainError{
+		Undelivered: undelivered + len(c.BaseClient.PendingReports()),
+		err:         err,
+	}
+}
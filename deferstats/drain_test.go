@@ -0,0 +1,70 @@
+//go:build go1.13
+// +build go1.13
+
+package deferstats
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainWithin(t *testing.T) {
+	defer rpms.ResetRPM()
+
+	dps := NewClient("token", nil)
+
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	dps.statsUrl = ts.URL
+
+	atomic.AddInt64(&dps.inFlight, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt64(&dps.inFlight, -1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := dps.DrainWithin(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-resbody:
+	default:
+		t.Error("not shipping the stats on drain")
+	}
+}
+
+func TestDrainWithinTimeout(t *testing.T) {
+	dps := NewClient("token", nil)
+
+	atomic.AddInt64(&dps.inFlight, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := dps.DrainWithin(ctx)
+
+	var drainErr *DrainError
+	if !errors.As(err, &drainErr) || drainErr.Undelivered != 2 {
+		t.Fatalf("not counting the undelivered request && stats, got %v", err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("not wrapping the context error")
+	}
+}
@@ -0,0 +1,34 @@
+package deferstats
+
+import (
+	"expvar"
+)
+
+// expvarName is the expvar the stats are published under
+const expvarName = "deferstats"
+
+// expvarStats are the stats published under expvarName, for the current
+// stats collection interval
+type expvarStats struct {
+	Rpms     Rpm              `json:"RPMs"`
+	InFlight int64            `json:"InFlight"`
+	HTTPs    []HTTPPercentile `json:"HTTPs"`
+}
+
+// PublishExpvars publishes the rpm counters, the requests in flight &&
+// the latency percentiles of the current stats collection interval as
+// the deferstats expvar, eg: for /debug/vars
+// publishing is skipped if the deferstats expvar already exists
+func (c *Client) PublishExpvars() {
+	if expvar.Get(expvarName) != nil {
+		return
+	}
+
+	expvar.Publish(expvarName, expvar.Func(func() interface{} {
+		return expvarStats{
+			Rpms:     rpms.List(),
+			InFlight: c.InFlight(),
+			HTTPs:    c.httpPercentiles(curlist.List()),
+		}
+	}))
+}
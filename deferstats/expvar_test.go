@@ -0,0 +1,34 @@
+package deferstats
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestPublishExpvars(t *testing.T) {
+	curlist.Reset()
+	defer curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{}
+	c.PublishExpvars()
+	c.PublishExpvars()
+
+	c.AddHTTP(DeferHTTP{Path: "/blah", StatusCode: 200})
+
+	v := expvar.Get(expvarName)
+	if v == nil {
+		t.Fatal("not publishing the expvar")
+	}
+
+	var stats expvarStats
+	err := json.Unmarshal([]byte(v.String()), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Rpms.StatusOk != 1 || len(stats.HTTPs) != 1 {
+		t.Error("not publishing the current stats")
+	}
+}
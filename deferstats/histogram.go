@@ -0,0 +1,73 @@
+package deferstats
+
+import (
+	"errors"
+)
+
+// defaultLatencyBuckets are the latency bucket bounds, in milliseconds,
+// used when none are set
+var defaultLatencyBuckets = []int{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// SetLatencyBuckets sets the upper bounds, in milliseconds, of the http
+// latency histogram buckets, eg: minutes for batch jobs
+// the bounds must be positive and increasing
+func (c *Client) SetLatencyBuckets(bounds []int) error {
+	if len(bounds) == 0 {
+		return errors.New("no latency bucket bounds")
+	}
+
+	for i, b := range bounds {
+		if b <= 0 {
+			return errors.New("latency bucket bounds must be positive")
+		}
+
+		if i > 0 && b <= bounds[i-1] {
+			return errors.New("latency bucket bounds must be increasing")
+		}
+	}
+
+	c.latencyBuckets = append([]int{}, bounds...)
+	return nil
+}
+
+// buckets returns the latency bucket bounds in use
+func (c *Client) buckets() []int {
+	if len(c.latencyBuckets) == 0 {
+		return defaultLatencyBuckets
+	}
+
+	return c.latencyBuckets
+}
+
+// httpPercentiles returns the HTTPPercentiles of https along w/their
+// latency histograms
+func (c *Client) httpPercentiles(https []DeferHTTP) []HTTPPercentile {
+	bounds := c.buckets()
+
+	histograms := make(map[string][]int64)
+	for _, dh := range https {
+		if _, ok := histograms[dh.Path]; !ok {
+			histograms[dh.Path] = make([]int64, len(bounds)+1)
+		}
+
+		histograms[dh.Path][bucket(bounds, dh.Time)]++
+	}
+
+	percentiles := getHTTPPercentiles(https)
+	for i := range percentiles {
+		percentiles[i].Buckets = histograms[percentiles[i].Sample.Path]
+	}
+
+	return percentiles
+}
+
+// bucket returns the index of the bucket t falls in
+func bucket(bounds []int, t int) int {
+	for i, b := range bounds {
+		if t <= b {
+			return i
+		}
+	}
+
+	return len(bounds)
+}
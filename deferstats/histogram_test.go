@@ -0,0 +1,54 @@
+package deferstats
+
+import (
+	"testing"
+)
+
+func TestSetLatencyBuckets(t *testing.T) {
+	c := &Client{}
+
+	if c.SetLatencyBuckets([]int{100, 50}) == nil {
+		t.Error("not rejecting decreasing bounds")
+	}
+
+	if c.SetLatencyBuckets([]int{0, 50}) == nil {
+		t.Error("not rejecting non positive bounds")
+	}
+
+	if c.SetLatencyBuckets(nil) == nil {
+		t.Error("not rejecting empty bounds")
+	}
+
+	if len(c.buckets()) != len(defaultLatencyBuckets) {
+		t.Error("not defaulting the bounds")
+	}
+
+	err := c.SetLatencyBuckets([]int{60000, 300000})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(c.buckets()) != 2 {
+		t.Error("not setting the bounds")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	c := &Client{}
+	c.SetLatencyBuckets([]int{100, 200})
+
+	var list []DeferHTTP
+	for _, ms := range []int{50, 100, 150, 500} {
+		list = append(list, DeferHTTP{Path: "/blah", Time: ms})
+	}
+
+	percentiles := c.httpPercentiles(list)
+	if len(percentiles) != 1 {
+		t.Fatal("not grouping by path")
+	}
+
+	b := percentiles[0].Buckets
+	if len(b) != 3 || b[0] != 2 || b[1] != 1 || b[2] != 1 {
+		t.Errorf("not counting the latency buckets, got %v", b)
+	}
+}
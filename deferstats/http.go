@@ -1,21 +1,37 @@
 package deferstats
 
 import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/go-zoo/bone"
+	"log"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/betacraft/deferclient/deferclient"
 )
 
 var (
 	// curlist holds an array of DeferHTTPs (uri && latency)
 	curlist = &deferHTTPList{}
+
+	// outlist holds the Outbound DeferHTTPs, kept apart from the requests
+	// handled so a failing dependency doesn't count as our own errors
+	outlist = &deferHTTPList{}
 	boneMux *bone.Mux
 )
 
@@ -32,6 +48,10 @@ type HTTPPercentile struct {
 	Mean   float64   `json:"Mean"`
 	StdDev float64   `json:"StdDev"`
 	Count  int64     `json:"Count"`
+
+	// Buckets counts the requests in each latency bucket, the last one
+	// counts those over the highest bound
+	Buckets []int64 `json:"Buckets,omitempty"`
 }
 
 type DeferHTTPs []DeferHTTP
@@ -132,6 +152,23 @@ func variance(vals DeferHTTPs, mean float64) float64 {
 	return tsum / float64(len(vals))
 }
 
+// ProblemKind tells panics apart from error responses
+type ProblemKind byte
+
+const (
+	// ProblemNone is a request that went fine
+	ProblemNone ProblemKind = iota
+	// ProblemErrorStatus is a request answered w/a 5xx status
+	ProblemErrorStatus
+	// ProblemPanic is a request whose handler panicked
+	ProblemPanic
+	// ProblemSlow is a request over SlowRequestThreshold
+	ProblemSlow
+	// ProblemError is a tracked run, span or outbound call that failed,
+	// eg: Track's fn returned an error
+	ProblemError
+)
+
 // DeferHTTP holds a single instance of a http query
 type DeferHTTP struct {
 	Path         string            `json:"Path"`
@@ -142,6 +179,69 @@ type DeferHTTP struct {
 	ParentSpanId int64             `json:"ParentSpanId"`
 	IsProblem    bool              `json:"IsProblem"`
 	Headers      map[string]string `json:"Headers"`
+	Proto        string            `json:"Proto,omitempty"`
+	TLS          bool              `json:"TLS"`
+	TLSVersion   string            `json:"TLSVersion,omitempty"`
+	TLSCipher    string            `json:"TLSCipher,omitempty"`
+	Handler      string            `json:"Handler,omitempty"`
+	UserAgent    string            `json:"UserAgent,omitempty"`
+	TraceId      string            `json:"TraceId,omitempty"`
+
+	// ProblemKind tells a panic apart from an error response, eg: to
+	// only page on panics
+	ProblemKind ProblemKind `json:"ProblemKind,omitempty"`
+
+	// Host && ServerName are the host a request was sent to && the tls
+	// sni it asked for, eg: per tenant vhost
+	Host       string `json:"Host,omitempty"`
+	ServerName string `json:"ServerName,omitempty"`
+
+	// Referer && Origin are the page && origin a request came from, eg:
+	// for front-end triggered errors
+	Referer string `json:"Referer,omitempty"`
+	Origin  string `json:"Origin,omitempty"`
+
+	// ContentType && Accept are the content type a request was sent in
+	// && the ones it accepts back, eg: for content negotiation bugs
+	ContentType string `json:"ContentType,omitempty"`
+	Accept      string `json:"Accept,omitempty"`
+
+	// RouteParams are the path parameters resolved by the router, see
+	// Client.RouteParams
+	RouteParams map[string]string `json:"RouteParams,omitempty"`
+
+	// Outbound flags the requests sent by a Transport, Timings break
+	// their latency down, see Transport.Timings
+	Outbound bool             `json:"Outbound,omitempty"`
+	Timings  *OutboundTimings `json:"Timings,omitempty"`
+
+	// Hijacked flags long lived connections taken over by the handler,
+	// eg: websockets
+	Hijacked bool `json:"Hijacked,omitempty"`
+
+	// Service is the service of the client recording the request, see
+	// WithService
+	Service string `json:"Service,omitempty"`
+}
+
+// IsSuccess reports if the request got a 2xx status
+func (d DeferHTTP) IsSuccess() bool {
+	return d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// IsClientError reports if the request got a 4xx status
+func (d DeferHTTP) IsClientError() bool {
+	return d.StatusCode >= 400 && d.StatusCode < 500
+}
+
+// IsServerError reports if the request got a 5xx status
+func (d DeferHTTP) IsServerError() bool {
+	return d.StatusCode >= 500 && d.StatusCode < 600
+}
+
+// IsSlowerThan reports if the request took longer than l
+func (d DeferHTTP) IsSlowerThan(l time.Duration) bool {
+	return time.Duration(d.Time)*time.Millisecond > l
 }
 
 // deferHTTPList is used to keep a list of DeferHTTP objects
@@ -166,6 +266,14 @@ type ResponseTracer struct {
 	size         int
 	SpanId       int64
 	ParentSpanId int64
+	TraceId      string
+	handler      string
+	done         int32
+	hijacked     bool
+
+	// Baggage are the key/value pairs propagated along the request in
+	// the X-Dpbaggage header
+	Baggage map[string]string
 }
 
 // Add adds a DeferHTTP object to the list
@@ -175,6 +283,13 @@ func (d *deferHTTPList) Add(item DeferHTTP) {
 	d.lock.Unlock()
 }
 
+// Len returns the number of entries in the list
+func (d *deferHTTPList) Len() int {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return len(d.list)
+}
+
 // List returns a copy of the list
 func (d *deferHTTPList) List() []DeferHTTP {
 	d.lock.RLock()
@@ -193,6 +308,12 @@ func (d *deferHTTPList) Reset() {
 	d.lock.Unlock()
 }
 
+// truncatedMarker is appended to truncated header values
+const truncatedMarker = "...(truncated)"
+
+// redactedValue replaces the values of RedactRouteParams
+const redactedValue = "[redacted]"
+
 // WritePanicResponse is an overridable function that, by default, writes the contents of the panic
 // error message with a 500 Internal Server Error.
 var WritePanicResponse = func(w http.ResponseWriter, r *http.Request, errMsg string) {
@@ -201,27 +322,199 @@ var WritePanicResponse = func(w http.ResponseWriter, r *http.Request, errMsg str
 }
 
 // appendHTTP adds a new http request to the list
-func appendHTTP(startTime time.Time, path string, method string, status_code int, span_id int64,
-	parent_span_id int64, isProblem bool, headers map[string]string) {
+func (c *Client) appendHTTP(startTime time.Time, r *http.Request, dh DeferHTTP) {
+	for _, method := range c.IgnoreMethods {
+		if strings.EqualFold(method, r.Method) {
+			return
+		}
+	}
+
+	dh.Path = r.Method + " " + boneMux.GetRequestRoute(r)
+	dh.Method = r.Method
+	dh.UserAgent = r.UserAgent()
+	dh.Host = r.Host
+	dh.ProblemKind = problemKind(dh)
+
+	if referer := r.Header["Referer"]; len(referer) > 0 {
+		dh.Referer = c.headerValue(referer)
+	}
+	if origin := r.Header["Origin"]; len(origin) > 0 {
+		dh.Origin = c.headerValue(origin)
+	}
+	if contentType := r.Header["Content-Type"]; len(contentType) > 0 {
+		dh.ContentType = c.headerValue(contentType)
+	}
+	if accept := r.Header["Accept"]; len(accept) > 0 {
+		dh.Accept = c.headerValue(accept)
+	}
+
+	setProtocol(&dh, r)
+
+	dh.RouteParams = c.routeParams(r)
+
+	c.record(startTime, dh)
+}
+
+// routeParams returns the RouteParams of r, w/the RedactRouteParams
+// redacted
+func (c *Client) routeParams(r *http.Request) map[string]string {
+	if c.RouteParams == nil {
+		return nil
+	}
+
+	params := c.RouteParams(r)
+	if len(params) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		redacted[k] = v
+	}
+
+	for _, k := range c.RedactRouteParams {
+		if _, ok := redacted[k]; ok {
+			redacted[k] = redactedValue
+		}
+	}
+
+	return redacted
+}
+
+// record sets the latency of dh since startTime and adds it to the list
+func (c *Client) record(startTime time.Time, dh DeferHTTP) {
 	endTime := time.Now()
 
-	t := int(((endTime.Sub(startTime)).Nanoseconds() / 1000000))
+	dh.Time = int(((endTime.Sub(startTime)).Nanoseconds() / 1000000))
 
-	rpms.Inc(status_code)
+	c.add(dh)
+}
 
-	dh := DeferHTTP{
+// NewDeferHTTP returns a DeferHTTP for a request timed outside of the
+// middleware, eg: by a custom transport, to pass to AddHTTP
+func NewDeferHTTP(path, method string, status int, latency time.Duration, spanId, parentSpanId int64, isProblem bool) DeferHTTP {
+	return DeferHTTP{
 		Path:         path,
 		Method:       method,
-		Time:         t,
-		StatusCode:   status_code,
-		SpanId:       span_id,
-		ParentSpanId: parent_span_id,
+		StatusCode:   status,
+		Time:         int(latency.Nanoseconds() / 1000000),
+		SpanId:       spanId,
+		ParentSpanId: parentSpanId,
 		IsProblem:    isProblem,
-		Headers:      headers,
+	}
+}
+
+// AddHTTP adds d, eg: from NewDeferHTTP, to the requests reported w/the
+// next stats
+func (c *Client) AddHTTP(d DeferHTTP) {
+	c.add(d)
+}
+
+// add adds dh, w/its latency set, to the list
+func (c *Client) add(dh DeferHTTP) {
+	if dh.Service == "" {
+		dh.Service = c.service
+	}
+
+	// the problems recorded outside of the middleware, eg: by AddHTTP
+	if dh.IsProblem && dh.ProblemKind == ProblemNone {
+		dh.ProblemKind = ProblemError
+		if dh.IsServerError() {
+			dh.ProblemKind = ProblemErrorStatus
+		}
+	}
+
+	if c.SlowRequestThreshold > 0 && dh.Time >= c.SlowRequestThreshold {
+		dh.IsProblem = true
+		if dh.ProblemKind == ProblemNone {
+			dh.ProblemKind = ProblemSlow
+		}
+	}
+
+	// the requests sent, eg: by a Transport, stay out of the rpms &&
+	// the percentiles of the requests handled
+	if dh.Outbound {
+		if c.shouldRecord(dh.Time, dh.IsProblem) {
+			outlist.Add(dh)
+		}
+		return
+	}
+
+	// records w/o a status, eg: from Track, aren't http responses
+	if dh.StatusCode != 0 {
+		rpms.Observe(dh.StatusCode, dh.Time)
+	}
+
+	if c.recent != nil {
+		c.recent.Add(dh)
+	}
+
+	if c.reservoir != nil {
+		c.reservoir.Add(dh)
+	}
+
+	if !c.shouldRecord(dh.Time, dh.IsProblem) {
+		return
 	}
 
 	curlist.Add(dh)
 
+	if c.FlushThreshold > 0 && curlist.Len() >= c.FlushThreshold {
+		c.flushFull()
+	}
+}
+
+// flushFull captures the stats before the next tick as the list hit
+// FlushThreshold, at most once every minFullFlushInterval
+func (c *Client) flushFull() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&c.root().lastFullFlush)
+	if now-last < int64(minFullFlushInterval) {
+		return
+	}
+
+	if atomic.CompareAndSwapInt64(&c.root().lastFullFlush, last, now) {
+		go c.capture()
+	}
+}
+
+// problemKind returns the ProblemKind of a request recorded by the
+// middleware, where IsProblem flags the panics it caught
+func problemKind(dh DeferHTTP) ProblemKind {
+	if dh.IsProblem {
+		return ProblemPanic
+	}
+
+	if dh.IsServerError() {
+		return ProblemErrorStatus
+	}
+
+	return ProblemNone
+}
+
+// setProtocol records the protocol && tls details of r on dh
+func setProtocol(dh *DeferHTTP, r *http.Request) {
+	dh.Proto = r.Proto
+
+	if r.TLS == nil {
+		return
+	}
+
+	dh.TLS = true
+	dh.ServerName = r.TLS.ServerName
+	dh.TLSVersion = tlsVersionName(r.TLS.Version)
+	dh.TLSCipher = tlsCipherName(r.TLS.CipherSuite)
+}
+
+// shouldRecord decides if a http request that took t milliseconds is
+// added to the list - problems and requests at or over LatencyThreshold
+// always are, faster ones are sampled at SampleRate
+func (c *Client) shouldRecord(t int, isProblem bool) bool {
+	if isProblem || t >= c.LatencyThreshold {
+		return true
+	}
+
+	return c.SampleRate > 0 && rand.Float64() < c.SampleRate
 }
 
 // GetSpanIdString is a convenience method to get the string equivalent
@@ -230,9 +523,23 @@ func GetSpanIdString(r http.ResponseWriter) string {
 	return strconv.FormatInt(GetSpanId(r), 10)
 }
 
-// GetSpanId returns the span id for this http request
+// GetTraceId returns the string trace id for this http request or "" if
+// it isn't traced
+func GetTraceId(r http.ResponseWriter) string {
+	mPtr, ok := (r).(*ResponseTracer)
+	if !ok {
+		return ""
+	}
+	return mPtr.TraceId
+}
+
+// GetSpanId returns the span id for this http request or zero if it
+// isn't traced, eg: w/DisableSpans
 func GetSpanId(r http.ResponseWriter) int64 {
-	mPtr := (r).(*ResponseTracer)
+	mPtr, ok := (r).(*ResponseTracer)
+	if !ok {
+		return 0
+	}
 	return mPtr.SpanId
 }
 
@@ -264,8 +571,29 @@ func (l *ResponseTracer) WriteHeader(s int) {
 	l.status = s
 }
 
-// Status returns the HTTP status code
+// Hijack is implementation of standard http Hijacker Hijack method, eg:
+// for websockets, and flags the request as hijacked
+func (l *ResponseTracer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := l.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter doesn't support hijacking")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		l.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+// Status returns the HTTP status code, StatusSwitchingProtocols for
+// hijacked connections w/o one
 func (l *ResponseTracer) Status() int {
+	if l.status == 0 && l.hijacked {
+		return http.StatusSwitchingProtocols
+	}
+
 	return l.status
 }
 
@@ -285,16 +613,49 @@ func (c *Client) HTTPHandlerFunc(f http.HandlerFunc) http.HandlerFunc {
 // request
 // this currently happens in a global list :( - TBFS
 func (c *Client) HTTPHandler(f http.Handler) http.Handler {
+	handler := ""
+	if c.GrabHandler {
+		handler = handlerName(f)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		startTime, tracer, headers := c.BeforeRequest(w, r)
+		tracer.handler = handler
+
+		// set before the handler gets a chance to commit the response
+		if c.EchoSpanHeader {
+			header := c.SpanHeader
+			if header == "" {
+				header = defaultSpanHeader
+			}
+			tracer.Header().Set(header, strconv.FormatInt(tracer.SpanId, 10))
+		}
+
+		// so handlers can defer BaseClient.Recover(r.Context())
+		ctx := deferclient.ContextWithSpanId(r.Context(), tracer.SpanId)
+		if tracer.Baggage != nil {
+			ctx = deferclient.ContextWithBaggage(ctx, tracer.Baggage)
+		}
+		if c.service != "" {
+			ctx = deferclient.ContextWithService(ctx, c.service)
+		}
+		r = r.WithContext(ctx)
 
 		defer func() {
 			if err := recover(); err != nil {
-				c.BaseClient.Prep(err, tracer.SpanId)
+				recoveryStart := time.Now()
+
+				if params := c.routeParams(r); params != nil {
+					ctx = deferclient.ContextWithRouteParams(ctx, params)
+				}
+
+				c.BaseClient.PrepContext(ctx, err, tracer.TraceId, c.service)
 				c.AfterRequest(startTime, tracer, r, headers, 500, true)
 
 				errorMsg := fmt.Sprintf("%v", err)
 				WritePanicResponse(w, r, errorMsg)
+
+				c.timeRecovery(recoveryStart, r)
 			}
 		}()
 
@@ -312,25 +673,200 @@ func (c *Client) BeforeRequest(w http.ResponseWriter, r *http.Request) (
 	tracer = &ResponseTracer{
 		w: w,
 	}
-	tracer.SpanId = tracer.newId()
+
+	atomic.AddInt64(&c.root().inFlight, 1)
+
+	// keep the bookkeeping from adding to a load spike
+	if c.shedding() {
+		return startTime, tracer, nil
+	}
+
+	if !c.DisableSpans {
+		tracer.SpanId = tracer.newId()
+	}
+
+	parentSpanHeader := c.parentSpanHeader()
 
 	// add headers
 	headers = make(map[string]string, len(r.Header))
 	for k, v := range r.Header {
-		headers[k] = strings.Join(v, ",")
+		headers[k] = c.headerValue(v)
 
 		// grab SOA tracing header if present
-		if k == "X-Dpparentspanid" {
+		if k == parentSpanHeader && !c.DisableSpans {
 			tracer.ParentSpanId, _ = strconv.ParseInt(v[0], 10, 64)
 		}
+
+		if k == deferclient.BaggageHeader {
+			tracer.Baggage = deferclient.ParseBaggage(v[0])
+		}
+	}
+
+	tracer.TraceId = c.traceId(r)
+
+	// nested in another HTTPHandler, make this span a child of its span
+	if !c.DisableSpans {
+		if spanId, traceId := enclosingSpan(w, r); spanId != 0 {
+			tracer.ParentSpanId = spanId
+			if traceId != "" {
+				tracer.TraceId = traceId
+			}
+		}
 	}
 
 	return startTime, tracer, headers
 }
 
+// parentSpanHeader returns the canonical ParentSpanHeader
+func (c *Client) parentSpanHeader() string {
+	if c.ParentSpanHeader == "" {
+		return defaultParentSpanHeader
+	}
+
+	return http.CanonicalHeaderKey(c.ParentSpanHeader)
+}
+
+// outboundSpanHeader returns the OutboundSpanHeader
+func (c *Client) outboundSpanHeader() string {
+	if c.OutboundSpanHeader == "" {
+		return defaultParentSpanHeader
+	}
+
+	return c.OutboundSpanHeader
+}
+
+// enclosingSpan returns the span && trace ids of the HTTPHandler wrapping
+// the one serving r, if any, from its ResponseTracer or else from the
+// context of r
+func enclosingSpan(w http.ResponseWriter, r *http.Request) (int64, string) {
+	if outer, ok := w.(*ResponseTracer); ok && outer.SpanId != 0 {
+		return outer.SpanId, outer.TraceId
+	}
+
+	return deferclient.SpanIdFromContext(r.Context()), ""
+}
+
+// timeRecovery logs && counts the recovery of a panic in r, started at
+// recoveryStart, if it took over SlowRecoveryThreshold
+func (c *Client) timeRecovery(recoveryStart time.Time, r *http.Request) {
+	if c.SlowRecoveryThreshold <= 0 {
+		return
+	}
+
+	took := time.Since(recoveryStart)
+	if took < c.SlowRecoveryThreshold {
+		return
+	}
+
+	atomic.AddInt64(&c.root().slowRecoveries, 1)
+	log.Printf("recovering a panic in %v %v took %v\n", r.Method, r.URL.Path, took)
+}
+
+// shedding counts a http request in the current second and returns true
+// if it is over ShedAboveRate
+func (c *Client) shedding() bool {
+	if c.ShedAboveRate <= 0 {
+		return false
+	}
+
+	now := time.Now().Unix()
+	second := atomic.LoadInt64(&c.root().shedSecond)
+	if second != now && atomic.CompareAndSwapInt64(&c.root().shedSecond, second, now) {
+		atomic.StoreInt64(&c.root().shedCount, 0)
+	}
+
+	return atomic.AddInt64(&c.root().shedCount, 1) > int64(c.ShedAboveRate)
+}
+
+// headerValue joins the values of a header, truncated to
+// MaxHeaderValueLength
+func (c *Client) headerValue(v []string) string {
+	value := strings.Join(v, ",")
+
+	if c.MaxHeaderValueLength > 0 && len(value) > c.MaxHeaderValueLength {
+		// back up to a rune boundary so the value stays valid utf-8
+		cut := c.MaxHeaderValueLength
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+
+		value = value[:cut] + truncatedMarker
+	}
+
+	return value
+}
+
 // AfterRequest is called after request processing in handler
 func (c *Client) AfterRequest(startTime time.Time, tracer *ResponseTracer, r *http.Request,
 	headers map[string]string, status_code int, isproblem bool) {
-	appendHTTP(startTime, r.Method + " "+ boneMux.GetRequestRoute(r), r.Method, status_code, tracer.SpanId,
-		tracer.ParentSpanId, isproblem, headers)
+	c.endRequest(&tracer.done)
+
+	c.appendHTTP(startTime, r, DeferHTTP{
+		StatusCode:   status_code,
+		SpanId:       tracer.SpanId,
+		ParentSpanId: tracer.ParentSpanId,
+		TraceId:      tracer.TraceId,
+		IsProblem:    isproblem,
+		Headers:      headers,
+		Handler:      tracer.handler,
+		Hijacked:     tracer.hijacked,
+	})
+}
+
+// endRequest takes a finished request off the in flight count, done
+// ensures it only happens once per request
+func (c *Client) endRequest(done *int32) {
+	if atomic.CompareAndSwapInt32(done, 0, 1) {
+		atomic.AddInt64(&c.root().inFlight, -1)
+	}
+}
+
+// InFlight returns the number of http requests being handled right now
+func (c *Client) InFlight() int64 {
+	return atomic.LoadInt64(&c.root().inFlight)
+}
+
+// traceId returns the string trace id of r read from TraceIdHeader, or
+// generated by TraceIdGenerator if it has none
+func (c *Client) traceId(r *http.Request) string {
+	if c.TraceIdHeader != "" {
+		if id := r.Header.Get(c.TraceIdHeader); id != "" {
+			return id
+		}
+	}
+
+	if c.TraceIdGenerator != nil {
+		return c.TraceIdGenerator()
+	}
+
+	return ""
+}
+
+// NewTraceId returns a random 128-bit trace id in hex, it can be used as
+// TraceIdGenerator
+func NewTraceId() string {
+	b := make([]byte, 16)
+	_, err := crand.Read(b)
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// handlerName returns the name of the function or type behind f
+// anonymous functions are named after their enclosing function, eg:
+// main.main.func1
+func handlerName(f http.Handler) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func {
+		return reflect.TypeOf(f).String()
+	}
+
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return ""
+	}
+
+	return fn.Name()
 }
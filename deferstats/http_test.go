@@ -2,13 +2,22 @@ package deferstats
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-zoo/bone"
+
+	"github.com/betacraft/deferclient/deferclient"
 )
 
 type TestJSON struct {
@@ -373,3 +382,571 @@ func TestPercentiles(t *testing.T) {
 	}
 
 }
+
+func TestShouldRecord(t *testing.T) {
+	c := &Client{LatencyThreshold: 100}
+
+	if !c.shouldRecord(150, false) {
+		t.Error("not recording a slow request")
+	}
+
+	if !c.shouldRecord(10, true) {
+		t.Error("not recording a problem")
+	}
+
+	if c.shouldRecord(10, false) {
+		t.Error("recording a fast request with no sample rate")
+	}
+
+	c.SampleRate = 1
+	if !c.shouldRecord(10, false) {
+		t.Error("not sampling a fast request")
+	}
+}
+
+func TestSetProtocol(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://127.0.0.1/", nil)
+
+	var dh DeferHTTP
+	setProtocol(&dh, r)
+
+	if dh.Proto != "HTTP/1.1" || dh.TLS {
+		t.Error("not recording a plaintext request")
+	}
+
+	r.TLS = &tls.ConnectionState{
+		Version:     tls.VersionTLS12,
+		CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		ServerName:  "tenant-a.example.com",
+	}
+	setProtocol(&dh, r)
+
+	if !dh.TLS || dh.TLSVersion != tlsVersionName(tls.VersionTLS12) {
+		t.Error("not recording the tls version")
+	}
+
+	if dh.TLSCipher != tlsCipherName(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) {
+		t.Error("not recording the tls cipher")
+	}
+
+	if dh.ServerName != "tenant-a.example.com" {
+		t.Error("not recording the tls sni")
+	}
+}
+
+func namedHandler(w http.ResponseWriter, r *http.Request) {
+}
+
+func TestHandlerName(t *testing.T) {
+	name := handlerName(http.HandlerFunc(namedHandler))
+	if name != "github.com/betacraft/deferclient/deferstats.namedHandler" {
+		t.Errorf("not naming a handler func, got %v", name)
+	}
+
+	name = handlerName(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if name != "github.com/betacraft/deferclient/deferstats.TestHandlerName.func1" {
+		t.Errorf("not naming an anonymous handler func, got %v", name)
+	}
+
+	name = handlerName(http.NewServeMux())
+	if name != "*http.ServeMux" {
+		t.Errorf("not naming a handler type, got %v", name)
+	}
+}
+
+func TestAppendHTTP(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+	boneMux = bone.New()
+
+	c := &Client{}
+
+	r, _ := http.NewRequest("GET", "http://127.0.0.1/blah", nil)
+	r.Header.Set("User-Agent", "some-app/1.0")
+	r.Header.Set("Referer", "https://example.com/cart")
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "application/xml")
+
+	c.appendHTTP(time.Now(), r, DeferHTTP{StatusCode: 200})
+
+	list := curlist.List()
+	if len(list) != 1 {
+		t.Fatal("should have a http in the list")
+	}
+
+	if list[0].Method != "GET" || list[0].StatusCode != 200 {
+		t.Error("not recording the request")
+	}
+
+	if list[0].UserAgent != "some-app/1.0" {
+		t.Error("not recording the user agent")
+	}
+
+	if list[0].Host != "127.0.0.1" {
+		t.Error("not recording the host")
+	}
+
+	if list[0].Referer != "https://example.com/cart" || list[0].Origin != "https://example.com" {
+		t.Error("not recording the referer && origin")
+	}
+
+	if list[0].ContentType != "application/json" || list[0].Accept != "application/xml" {
+		t.Error("not recording the content type && accept")
+	}
+}
+
+func TestIgnoreMethods(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+	boneMux = bone.New()
+
+	c := &Client{IgnoreMethods: []string{"OPTIONS", "head"}}
+
+	for _, method := range []string{"OPTIONS", "HEAD", "GET"} {
+		r, _ := http.NewRequest(method, "http://127.0.0.1/blah", nil)
+		c.appendHTTP(time.Now(), r, DeferHTTP{StatusCode: 200})
+	}
+
+	list := curlist.List()
+	if len(list) != 1 || list[0].Method != "GET" {
+		t.Error("not ignoring the IgnoreMethods requests")
+	}
+}
+
+func TestAddHTTP(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{}
+	c.AddHTTP(NewDeferHTTP("/pkg.Service/Method", "POST", 200, 30*time.Millisecond, 2, 1, false))
+
+	list := curlist.List()
+	if len(list) != 1 {
+		t.Fatal("not adding the http")
+	}
+
+	if list[0].Path != "/pkg.Service/Method" || list[0].Time != 30 || list[0].ParentSpanId != 1 {
+		t.Error("not recording the http details")
+	}
+}
+
+func TestProblemKind(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+	boneMux = bone.New()
+
+	c := &Client{}
+
+	r, _ := http.NewRequest("GET", "http://127.0.0.1/blah", nil)
+	c.appendHTTP(time.Now(), r, DeferHTTP{StatusCode: 200})
+	c.appendHTTP(time.Now(), r, DeferHTTP{StatusCode: 500})
+	c.appendHTTP(time.Now(), r, DeferHTTP{StatusCode: 500, IsProblem: true})
+
+	list := curlist.List()
+	if len(list) != 3 {
+		t.Fatal("not recording the requests")
+	}
+
+	if list[0].ProblemKind != ProblemNone || list[1].ProblemKind != ProblemErrorStatus || list[2].ProblemKind != ProblemPanic {
+		t.Error("not telling panics apart from error responses")
+	}
+}
+
+func TestSlowRequestThreshold(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{SlowRequestThreshold: 1000}
+	c.AddHTTP(DeferHTTP{Path: "/fast", StatusCode: 200, Time: 10})
+	c.AddHTTP(DeferHTTP{Path: "/slow", StatusCode: 200, Time: 8000})
+
+	list := curlist.List()
+	if len(list) != 2 {
+		t.Fatal("not recording the requests")
+	}
+
+	if list[0].IsProblem {
+		t.Error("flagging a fast request")
+	}
+
+	if !list[1].IsProblem || list[1].ProblemKind != ProblemSlow {
+		t.Error("not flagging a slow successful request")
+	}
+}
+
+func TestEchoSpanHeader(t *testing.T) {
+	boneMux = bone.New()
+	defer rpms.ResetRPM()
+
+	dps := &Client{EchoSpanHeader: true}
+
+	ts := httptest.NewServer(dps.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Dpspanid") == "" {
+		t.Error("not echoing the span id")
+	}
+}
+
+func TestDeferHTTPPredicates(t *testing.T) {
+	ok := DeferHTTP{StatusCode: 204, Time: 100}
+	notFound := DeferHTTP{StatusCode: 404}
+	failed := DeferHTTP{StatusCode: 503}
+
+	if !ok.IsSuccess() || notFound.IsSuccess() || failed.IsSuccess() {
+		t.Error("not classifying successes")
+	}
+
+	if ok.IsClientError() || !notFound.IsClientError() || failed.IsClientError() {
+		t.Error("not classifying client errors")
+	}
+
+	if ok.IsServerError() || notFound.IsServerError() || !failed.IsServerError() {
+		t.Error("not classifying server errors")
+	}
+
+	if !ok.IsSlowerThan(50*time.Millisecond) || ok.IsSlowerThan(100*time.Millisecond) {
+		t.Error("not comparing latency")
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	c := &Client{}
+
+	if c.headerValue([]string{"a", "b"}) != "a,b" {
+		t.Error("not joining header values")
+	}
+
+	c.MaxHeaderValueLength = 4
+	if c.headerValue([]string{"abcdefgh"}) != "abcd...(truncated)" {
+		t.Error("not truncating long header values")
+	}
+
+	if c.headerValue([]string{"abc"}) != "abc" {
+		t.Error("truncating short header values")
+	}
+
+	// é is 2 bytes, the 4th byte is in the middle of the second one
+	value := c.headerValue([]string{"aééé"})
+	if value != "aé...(truncated)" || !utf8.ValidString(value) {
+		t.Errorf("splitting a multibyte rune, got %q", value)
+	}
+}
+
+func TestTraceId(t *testing.T) {
+	c := &Client{TraceIdHeader: "X-Trace-Id"}
+
+	r, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	r.Header.Set("X-Trace-Id", "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	_, tracer, _ := c.BeforeRequest(httptest.NewRecorder(), r)
+	if tracer.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error("not propagating the trace id")
+	}
+
+	r.Header.Del("X-Trace-Id")
+
+	_, tracer, _ = c.BeforeRequest(httptest.NewRecorder(), r)
+	if tracer.TraceId != "" {
+		t.Error("generating a trace id w/o a generator")
+	}
+
+	c.TraceIdGenerator = NewTraceId
+
+	_, tracer, _ = c.BeforeRequest(httptest.NewRecorder(), r)
+	if len(tracer.TraceId) != 32 {
+		t.Error("not generating a 128-bit trace id")
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	boneMux = bone.New()
+	defer rpms.ResetRPM()
+
+	dps := &Client{BaseClient: deferclient.NewDeferPanicClient("token")}
+	dps.BaseClient.NoPost = true
+
+	handling := make(chan bool)
+	release := make(chan bool)
+
+	ts := httptest.NewServer(dps.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handling <- true
+		<-release
+		if r.URL.Path == "/panic" {
+			panic("there is no need to panic")
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/ok", "/panic"} {
+		done := make(chan bool)
+		go func() {
+			resp, err := http.Get(ts.URL + path)
+			if err == nil {
+				resp.Body.Close()
+			}
+			done <- true
+		}()
+
+		<-handling
+		if dps.InFlight() != 1 {
+			t.Errorf("not counting the request in flight, got %v", dps.InFlight())
+		}
+
+		release <- true
+		<-done
+
+		if dps.InFlight() != 0 {
+			t.Errorf("not counting the finished request %v, got %v", path, dps.InFlight())
+		}
+	}
+}
+
+func TestSpanIdContext(t *testing.T) {
+	boneMux = bone.New()
+	defer rpms.ResetRPM()
+
+	dps := &Client{}
+
+	ts := httptest.NewServer(dps.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deferclient.SpanIdFromContext(r.Context()) != GetSpanId(w) {
+			t.Error("not passing the span id in the request context")
+		}
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestShedding(t *testing.T) {
+	dps := &Client{ShedAboveRate: 1}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Test", "1")
+
+	_, tracer, headers := dps.BeforeRequest(httptest.NewRecorder(), r)
+	if tracer.SpanId == 0 || headers["X-Test"] != "1" {
+		t.Error("shedding under ShedAboveRate")
+	}
+
+	_, tracer, headers = dps.BeforeRequest(httptest.NewRecorder(), r)
+	if tracer.SpanId != 0 || headers != nil {
+		t.Error("not shedding over ShedAboveRate")
+	}
+
+	if dps.InFlight() != 2 {
+		t.Error("not counting shed requests in flight")
+	}
+}
+
+func TestSlowRecovery(t *testing.T) {
+	boneMux = bone.New()
+	defer rpms.ResetRPM()
+
+	dps := &Client{
+		BaseClient:            deferclient.NewDeferPanicClient("token"),
+		SlowRecoveryThreshold: time.Nanosecond,
+	}
+	dps.BaseClient.NoPost = true
+
+	ts := httptest.NewServer(dps.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("there is no need to panic")
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if atomic.LoadInt64(&dps.slowRecoveries) != 1 {
+		t.Error("not counting the slow recovery")
+	}
+}
+
+func TestHijack(t *testing.T) {
+	curlist.Reset()
+	boneMux = bone.New()
+	defer rpms.ResetRPM()
+
+	dps := &Client{}
+
+	ts := httptest.NewServer(dps.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		rw.Flush()
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	ioutil.ReadAll(conn)
+
+	// the handler is still returning once the connection is closed
+	list := curlist.List()
+	for i := 0; i < 100 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		list = curlist.List()
+	}
+
+	if len(list) != 1 {
+		t.Fatal("not recording the hijacked request")
+	}
+
+	if list[0].StatusCode != http.StatusSwitchingProtocols || !list[0].Hijacked {
+		t.Error("not flagging the hijacked request")
+	}
+}
+
+func TestDisableSpans(t *testing.T) {
+	dps := &Client{DisableSpans: true}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Dpparentspanid", "42")
+
+	_, tracer, _ := dps.BeforeRequest(httptest.NewRecorder(), r)
+	if tracer.SpanId != 0 || tracer.ParentSpanId != 0 {
+		t.Error("generating span ids w/DisableSpans")
+	}
+
+	if GetSpanId(tracer) != 0 || GetSpanId(httptest.NewRecorder()) != 0 {
+		t.Error("not returning a zero span id")
+	}
+}
+
+func TestFlushThreshold(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{
+		GrabHTTP:       true,
+		FlushThreshold: 2,
+		BaseClient:     deferclient.NewDeferPanicClient("token"),
+	}
+	c.BaseClient.NoPost = true
+
+	c.AddHTTP(DeferHTTP{Path: "/one"})
+	if curlist.Len() != 1 {
+		t.Fatal("flushing under FlushThreshold")
+	}
+
+	c.AddHTTP(DeferHTTP{Path: "/two"})
+
+	for i := 0; i < 100 && curlist.Len() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if curlist.Len() != 0 {
+		t.Error("not flushing at FlushThreshold")
+	}
+}
+
+func TestNestedSpans(t *testing.T) {
+	c := &Client{}
+
+	r := httptest.NewRequest("GET", "/", nil)
+
+	_, outer, _ := c.BeforeRequest(httptest.NewRecorder(), r)
+	outer.TraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	_, inner, _ := c.BeforeRequest(outer, r)
+	if inner.ParentSpanId != outer.SpanId || inner.SpanId == outer.SpanId {
+		t.Error("not nesting the span in the outer one")
+	}
+
+	if inner.TraceId != outer.TraceId {
+		t.Error("not keeping the trace of the outer span")
+	}
+
+	r = r.WithContext(deferclient.ContextWithSpanId(r.Context(), 42))
+
+	_, inner, _ = c.BeforeRequest(httptest.NewRecorder(), r)
+	if inner.ParentSpanId != 42 {
+		t.Error("not nesting the span in the one of the context")
+	}
+}
+
+func TestRouteParams(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+	c.RouteParams = func(r *http.Request) map[string]string {
+		return map[string]string{"userID": r.URL.Query().Get("user"), "token": "secret"}
+	}
+	c.RedactRouteParams = []string{"token"}
+
+	h := c.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("there is no need to panic")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users?user=123", nil))
+
+	dj := <-reports
+	if dj.RouteParams["userID"] != "123" || dj.RouteParams["token"] != redactedValue {
+		t.Errorf("not reporting the redacted route params, got %v", dj.RouteParams)
+	}
+
+	list := curlist.List()
+	if len(list) != 1 || list[0].RouteParams["userID"] != "123" || list[0].RouteParams["token"] != redactedValue {
+		t.Errorf("not recording the redacted route params, got %+v", list)
+	}
+
+	// no capture by default
+	c.RouteParams = nil
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users?user=123", nil))
+
+	if dj := <-reports; dj.RouteParams != nil {
+		t.Errorf("reporting route params w/o RouteParams, got %v", dj.RouteParams)
+	}
+}
+
+// reportingClient returns a stats client, w/no requests recorded, whose
+// reports goto the returned chan, of size 1, in place of being posted,
+// posting anything fails t
+// done closes the api the client points at && resets the recorded
+// requests
+func reportingClient(t *testing.T) (c *Client, reports chan deferclient.DeferJSON, done func()) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("posting to %v in place of the ReportChan", r.URL.Path)
+	}))
+
+	curlist.Reset()
+	boneMux = bone.New()
+
+	reports = make(chan deferclient.DeferJSON, 1)
+
+	c = &Client{BaseClient: deferclient.NewDeferPanicClient("token")}
+	c.BaseClient.SetBaseURL(ts.URL)
+	c.BaseClient.ReportChan = reports
+
+	done = func() {
+		ts.Close()
+		curlist.Reset()
+		rpms.ResetRPM()
+	}
+
+	return c, reports, done
+}
@@ -0,0 +1,36 @@
+package deferstats
+
+import (
+	"net/http"
+
+	"github.com/betacraft/deferclient/deferclient"
+)
+
+// ReportHTTPError reports err, the cause of the statusCode response to
+// r, w/the method, path && headers of r && its span, eg: when a handler
+// answers w/http.Error instead of panicking
+// w is the ResponseWriter HTTPHandler passed the handler
+func (c *Client) ReportHTTPError(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[k] = c.headerValue(v)
+	}
+
+	ctx := deferclient.ContextWithRequest(r.Context(), &deferclient.RequestInfo{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Host:       r.Host,
+		StatusCode: statusCode,
+		Headers:    headers,
+	})
+
+	if spanId := GetSpanId(w); spanId != 0 {
+		ctx = deferclient.ContextWithSpanId(ctx, spanId)
+	}
+
+	if params := c.routeParams(r); params != nil {
+		ctx = deferclient.ContextWithRouteParams(ctx, params)
+	}
+
+	c.BaseClient.PrepContext(ctx, err, GetTraceId(w), c.service)
+}
@@ -0,0 +1,34 @@
+package deferstats
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReportHTTPError(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	var spanId int64
+	h := c.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spanId = GetSpanId(w)
+		c.ReportHTTPError(w, r, 500, errors.New("db down"))
+		http.Error(w, "db down", 500)
+	}))
+
+	r := httptest.NewRequest("POST", "/orders", nil)
+	r.Header.Set("X-Test", "1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	dj := <-reports
+	if dj.Msg != "db down" || dj.SpanId != spanId || dj.SpanId == 0 {
+		t.Errorf("not reporting the error w/its span, got %+v", dj)
+	}
+
+	if dj.Request == nil || dj.Request.Method != "POST" || dj.Request.Path != "/orders" ||
+		dj.Request.StatusCode != 500 || dj.Request.Headers["X-Test"] != "1" {
+		t.Errorf("not reporting the request, got %+v", dj.Request)
+	}
+}
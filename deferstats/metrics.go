@@ -0,0 +1,97 @@
+package deferstats
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricsContentType is the content type of the prometheus text
+// exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelEscaper escapes prometheus label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler returns a http handler writing the requests in flight,
+// the requests && their latency by status code && the latency histogram by
+// path of the current stats collection interval in the prometheus text
+// exposition format, eg: to mount at /metrics for an existing scraper
+// the counts are those of the interval, so they are exposed as gauges
+func (c *Client) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+
+		bw := bufio.NewWriter(w)
+		c.writeMetrics(bw, rpms.List(), curlist.List())
+		bw.Flush()
+	})
+}
+
+// writeMetrics writes the metrics of rpm && https to w
+func (c *Client) writeMetrics(w *bufio.Writer, rpm Rpm, https []DeferHTTP) {
+	fmt.Fprintln(w, "# HELP deferstats_http_requests_in_flight The http requests being handled.")
+	fmt.Fprintln(w, "# TYPE deferstats_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "deferstats_http_requests_in_flight %d\n", c.InFlight())
+
+	codes := make([]int, 0, len(rpm.Latencies))
+	for code := range rpm.Latencies {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	fmt.Fprintln(w, "# HELP deferstats_http_requests The http requests of the interval by status code.")
+	fmt.Fprintln(w, "# TYPE deferstats_http_requests gauge")
+	for _, code := range codes {
+		fmt.Fprintf(w, "deferstats_http_requests{code=\"%d\"} %d\n", code, rpm.Latencies[code].Count)
+	}
+
+	fmt.Fprintln(w, "# HELP deferstats_http_latency_milliseconds The total latency of the http requests of the interval by status code.")
+	fmt.Fprintln(w, "# TYPE deferstats_http_latency_milliseconds gauge")
+	for _, code := range codes {
+		fmt.Fprintf(w, "deferstats_http_latency_milliseconds{code=\"%d\"} %d\n", code, rpm.Latencies[code].Sum)
+	}
+
+	bounds := c.buckets()
+	histograms := make(map[string][]int64)
+	sums := make(map[string]int64)
+	for _, dh := range https {
+		if _, ok := histograms[dh.Path]; !ok {
+			histograms[dh.Path] = make([]int64, len(bounds)+1)
+		}
+
+		histograms[dh.Path][bucket(bounds, dh.Time)]++
+		sums[dh.Path] += int64(dh.Time)
+	}
+
+	paths := make([]string, 0, len(histograms))
+	for path := range histograms {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintln(w, "# HELP deferstats_http_request_duration_milliseconds The latency of the http requests of the interval by path.")
+	fmt.Fprintln(w, "# TYPE deferstats_http_request_duration_milliseconds histogram")
+	for _, path := range paths {
+		label := labelEscaper.Replace(path)
+
+		// prometheus buckets are cumulative
+		var count int64
+		for i, n := range histograms[path] {
+			count += n
+
+			le := "+Inf"
+			if i < len(bounds) {
+				le = strconv.Itoa(bounds[i])
+			}
+
+			fmt.Fprintf(w, "deferstats_http_request_duration_milliseconds_bucket{path=\"%s\",le=\"%s\"} %d\n", label, le, count)
+		}
+
+		fmt.Fprintf(w, "deferstats_http_request_duration_milliseconds_sum{path=\"%s\"} %d\n", label, sums[path])
+		fmt.Fprintf(w, "deferstats_http_request_duration_milliseconds_count{path=\"%s\"} %d\n", label, count)
+	}
+}
@@ -0,0 +1,52 @@
+package deferstats
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// metricLine matches a sample line of the prometheus text format
+var metricLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\]|\\.)*",?)*\})? -?[0-9.e+]+$`)
+
+func TestMetricsHandler(t *testing.T) {
+	curlist.Reset()
+	defer curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{}
+	c.SetLatencyBuckets([]int{10, 100})
+
+	c.AddHTTP(DeferHTTP{Path: "GET /a", StatusCode: 200, Time: 5})
+	c.AddHTTP(DeferHTTP{Path: "GET /a", StatusCode: 200, Time: 50})
+	c.AddHTTP(DeferHTTP{Path: `GET /"b"`, StatusCode: 500, Time: 500})
+
+	w := httptest.NewRecorder()
+	c.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Header().Get("Content-Type") != metricsContentType {
+		t.Error("not setting the exposition format content type")
+	}
+
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if !strings.HasPrefix(line, "# ") && !metricLine.MatchString(line) {
+			t.Errorf("malformed metric line %q", line)
+		}
+	}
+
+	for _, want := range []string{
+		`deferstats_http_requests{code="200"} 2`,
+		`deferstats_http_latency_milliseconds{code="500"} 500`,
+		`deferstats_http_request_duration_milliseconds_bucket{path="GET /a",le="100"} 2`,
+		`deferstats_http_request_duration_milliseconds_bucket{path="GET /\"b\"",le="+Inf"} 1`,
+		`deferstats_http_request_duration_milliseconds_count{path="GET /a"} 2`,
+		`deferstats_http_request_duration_milliseconds_sum{path="GET /a"} 55`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
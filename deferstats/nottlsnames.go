@@ -0,0 +1,20 @@
+//go:build !go1.21
+// +build !go1.21
+
+package deferstats
+
+import (
+	"fmt"
+)
+
+// tlsVersionName returns the tls version in hex, eg: 0x0304, as go <
+// 1.21 doesn't name them
+func tlsVersionName(version uint16) string {
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// tlsCipherName returns the tls cipher suite in hex, eg: 0x1301, as go
+// < 1.21 doesn't name all of them
+func tlsCipherName(cipher uint16) string {
+	return fmt.Sprintf("0x%04X", cipher)
+}
@@ -0,0 +1,62 @@
+package deferstats
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// recentRing keeps the last DeferHTTPs added to it
+type recentRing struct {
+	list []DeferHTTP
+	next int
+	full bool
+	sync.Mutex
+}
+
+// Add adds dh, overwriting the oldest once the ring is full
+func (r *recentRing) Add(dh DeferHTTP) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.list[r.next] = dh
+	r.next = (r.next + 1) % len(r.list)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns a copy of the ring from the oldest to the newest
+func (r *recentRing) List() []DeferHTTP {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.full {
+		return append([]DeferHTTP{}, r.list[:r.next]...)
+	}
+
+	return append(append([]DeferHTTP{}, r.list[r.next:]...), r.list[:r.next]...)
+}
+
+// KeepRecentRequests keeps the last n http requests to attach them to
+// every panic reported by the BaseClient, eg: to see what led to a crash
+// it is meant to be called once, before handling requests
+func (c *Client) KeepRecentRequests(n int) {
+	if n <= 0 {
+		return
+	}
+
+	c.recent = &recentRing{list: make([]DeferHTTP, n)}
+	c.BaseClient.RecentRequests = c.recentRequests
+}
+
+// recentRequests returns the recent http requests as json
+func (c *Client) recentRequests() json.RawMessage {
+	b, err := json.Marshal(c.recent.List())
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return b
+}
@@ -0,0 +1,46 @@
+package deferstats
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/betacraft/deferclient/deferclient"
+)
+
+func TestRecentRing(t *testing.T) {
+	r := &recentRing{list: make([]DeferHTTP, 2)}
+
+	r.Add(DeferHTTP{Path: "/one"})
+	if list := r.List(); len(list) != 1 || list[0].Path != "/one" {
+		t.Error("not keeping the requests")
+	}
+
+	r.Add(DeferHTTP{Path: "/two"})
+	r.Add(DeferHTTP{Path: "/three"})
+
+	list := r.List()
+	if len(list) != 2 || list[0].Path != "/two" || list[1].Path != "/three" {
+		t.Errorf("not keeping the last requests in order, got %v", list)
+	}
+}
+
+func TestKeepRecentRequests(t *testing.T) {
+	curlist.Reset()
+	defer curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{BaseClient: deferclient.NewDeferPanicClient("token")}
+	c.KeepRecentRequests(2)
+
+	c.AddHTTP(DeferHTTP{Path: "/cart", StatusCode: 200})
+
+	var recent []DeferHTTP
+	err := json.Unmarshal(c.BaseClient.RecentRequests(), &recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recent) != 1 || recent[0].Path != "/cart" {
+		t.Error("not attaching the recent requests")
+	}
+}
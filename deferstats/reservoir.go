@@ -0,0 +1,58 @@
+package deferstats
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// reservoir keeps a fixed size uniform random sample of the DeferHTTPs
+// added to it, w/reservoir sampling
+type reservoir struct {
+	list []DeferHTTP
+	size int
+	seen int64
+	sync.Mutex
+}
+
+// Add adds dh to the sample, replacing a random one once it is full so
+// each DeferHTTP seen has the same chance to be in it
+func (r *reservoir) Add(dh DeferHTTP) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.seen++
+	if len(r.list) < r.size {
+		r.list = append(r.list, dh)
+		return
+	}
+
+	if i := rand.Int63n(r.seen); i < int64(r.size) {
+		r.list[i] = dh
+	}
+}
+
+// Reset returns the sample && the number of DeferHTTPs it was taken from
+// and starts a new one
+func (r *reservoir) Reset() ([]DeferHTTP, int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	list, seen := r.list, r.seen
+	r.list = make([]DeferHTTP, 0, r.size)
+	r.seen = 0
+
+	return list, seen
+}
+
+// SampleReservoir keeps a uniform sample of n of all the http requests
+// of each stats collection interval, not only the slow ones, to ship the
+// latency percentiles of the sample as ReservoirHTTPs, eg: for unbiased
+// percentiles at bounded memory
+// it is meant to be called once, before handling requests
+func (c *Client) SampleReservoir(n int) {
+	if n <= 0 {
+		return
+	}
+
+	c.reservoir = &reservoir{list: make([]DeferHTTP, 0, n), size: n}
+}
@@ -0,0 +1,58 @@
+package deferstats
+
+import (
+	"testing"
+)
+
+func TestReservoir(t *testing.T) {
+	r := &reservoir{size: 10}
+
+	for i := 0; i < 1000; i++ {
+		r.Add(DeferHTTP{Time: i})
+	}
+
+	sample, seen := r.Reset()
+	if len(sample) != 10 || seen != 1000 {
+		t.Fatalf("want 10 of 1000, got %d of %d", len(sample), seen)
+	}
+
+	late := 0
+	for _, dh := range sample {
+		if dh.Time >= 100 {
+			late++
+		}
+	}
+	if late == 0 {
+		t.Error("not sampling past the first requests")
+	}
+
+	if sample, seen = r.Reset(); len(sample) != 0 || seen != 0 {
+		t.Error("not starting a new sample")
+	}
+}
+
+func TestSampleReservoir(t *testing.T) {
+	curlist.Reset()
+	defer curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{GrabHTTP: true, LatencyThreshold: 1000}
+	c.SampleReservoir(5)
+
+	for i := 0; i < 3; i++ {
+		c.AddHTTP(DeferHTTP{Path: "/fast", StatusCode: 200, Time: 1})
+	}
+
+	ds, ok := c.collect()
+	if !ok {
+		t.Fatal("not collecting")
+	}
+
+	if len(ds.HTTPs) != 0 {
+		t.Error("recording fast requests under LatencyThreshold")
+	}
+
+	if ds.ReservoirSeen != 3 || len(ds.ReservoirHTTPs) != 1 {
+		t.Errorf("not shipping the reservoir, got %d seen && %v", ds.ReservoirSeen, ds.ReservoirHTTPs)
+	}
+}
@@ -26,6 +26,26 @@ type Rpm struct {
 
 	StatusInternalServerError int `json:"500,omitempty"`
 	StatusServiceUnavailable  int `json:"503,omitempty"`
+
+	// Latencies accumulate the latency of the requests by status code,
+	// eg: to tell fast failing 500s from slow timing out ones
+	Latencies map[int]StatusLatency `json:"Latencies,omitempty"`
+}
+
+// StatusLatency holds the count && the total latency in milliseconds of
+// the requests w/a status code
+type StatusLatency struct {
+	Count int `json:"Count"`
+	Sum   int `json:"Sum"`
+}
+
+// Avg returns the average latency in milliseconds
+func (s StatusLatency) Avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+
+	return float64(s.Sum) / float64(s.Count)
 }
 
 // ResetRPM clobbers old rpmset
@@ -42,9 +62,32 @@ func (r *rpmSet) List() Rpm {
 	defer r.lock.Unlock()
 
 	rpm := r.rpm
+	if r.rpm.Latencies != nil {
+		rpm.Latencies = make(map[int]StatusLatency, len(r.rpm.Latencies))
+		for code, l := range r.rpm.Latencies {
+			rpm.Latencies[code] = l
+		}
+	}
 	return rpm
 }
 
+// Observe counts a request w/status code that took t milliseconds
+func (r *rpmSet) Observe(code int, t int) {
+	r.Inc(code)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.rpm.Latencies == nil {
+		r.rpm.Latencies = make(map[int]StatusLatency)
+	}
+
+	l := r.rpm.Latencies[code]
+	l.Count++
+	l.Sum += t
+	r.rpm.Latencies[code] = l
+}
+
 func (r *rpmSet) Inc(code int) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
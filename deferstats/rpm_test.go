@@ -109,3 +109,26 @@ func TestClearRPM(t *testing.T) {
 	}
 
 }
+
+func TestObserveLatency(t *testing.T) {
+	rpms.ResetRPM()
+	defer rpms.ResetRPM()
+
+	rpms.Observe(500, 10)
+	rpms.Observe(500, 30)
+	rpms.Observe(200, 5)
+
+	rpmz := rpms.List()
+	if rpmz.StatusInternalServerError != 2 {
+		t.Error("not counting the observed requests")
+	}
+
+	if rpmz.Latencies[500].Avg() != 20 || rpmz.Latencies[200].Count != 1 {
+		t.Error("not accumulating the latency per status code")
+	}
+
+	rpms.Observe(500, 60)
+	if rpmz.Latencies[500].Count != 2 {
+		t.Error("not copying the latencies")
+	}
+}
@@ -0,0 +1,37 @@
+package deferstats
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// InstallSignalHandler traps SIGINT && SIGTERM to Flush, waiting up to
+// timeout, before letting the process exit, eg: so the last stats window
+// isn't lost during a rolling deploy
+// it is opt-in so apps that handle signals themselves are left alone
+func (c *Client) InstallSignalHandler(timeout time.Duration) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		log.Printf("flushing deferpanic on %v\n", sig)
+
+		if !c.Flush(timeout) {
+			log.Println("deferpanic flush timed out")
+		}
+
+		// reissue the signal w/the default behavior
+		signal.Stop(sigs)
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	}()
+}
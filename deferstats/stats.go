@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-zoo/bone"
@@ -24,6 +25,17 @@ var (
 	Token string
 )
 
+// defaultSpanHeader is the SpanHeader used when none is set
+const defaultSpanHeader = "X-Dpspanid"
+
+// defaultParentSpanHeader is the ParentSpanHeader && OutboundSpanHeader
+// used when none is set
+const defaultParentSpanHeader = "X-Dpparentspanid"
+
+// minFullFlushInterval is the minimum time between two captures
+// triggered by FlushThreshold
+const minFullFlushInterval = time.Second
+
 // DeferStats captures {mem, gc, goroutines and http calls}
 type DeferStats struct {
 	Mem        string           `json:"Mem"`
@@ -37,15 +49,53 @@ type DeferStats struct {
 	HTTPs      []HTTPPercentile `json:"HTTPs,omitempty"`
 	DBs        []DeferDB        `json:"DBs,omitempty"`
 	Rpms       Rpm              `json:"RPMs,omitempty"`
+	InFlight   string           `json:"InFlight,omitempty"`
+
+	// SlowRecoveries counts the panics that were slow to recover
+	SlowRecoveries int64 `json:"SlowRecoveries,omitempty"`
+
+	// LatencyBuckets are the bounds of the Buckets of each HTTPs entry
+	LatencyBuckets []int `json:"LatencyBuckets,omitempty"`
+
+	// ReservoirHTTPs are the percentiles of a uniform sample of all the
+	// http requests, ReservoirSeen counts those sampled from - see
+	// SampleReservoir
+	ReservoirHTTPs []HTTPPercentile `json:"ReservoirHTTPs,omitempty"`
+	ReservoirSeen  int64            `json:"ReservoirSeen,omitempty"`
+
+	// OutboundHTTPs are the percentiles of the requests sent, eg: by a
+	// Transport, apart from the HTTPs handled
+	OutboundHTTPs []HTTPPercentile `json:"OutboundHTTPs,omitempty"`
 }
 
 // Client is the client for making metrics requests to the
 // defer panic api
 type Client struct {
+	// inFlight counts the http requests being handled, it is first to
+	// keep it 64-bit aligned for atomic access
+	inFlight int64
+
+	// shedSecond && shedCount count the http requests of the current
+	// second for ShedAboveRate, 64-bit aligned too
+	shedSecond int64
+	shedCount  int64
+
+	// slowRecoveries counts the panics whose recovery took over
+	// SlowRecoveryThreshold, 64-bit aligned too
+	slowRecoveries int64
+
+	// lastFullFlush is when FlushThreshold last triggered a capture in
+	// unix nanoseconds, 64-bit aligned too
+	lastFullFlush int64
+
+	// parent is the client this one is a WithService copy of
+	parent *Client
+
 	// statsFrequency controls how often to report into deferpanic in seconds
 	statsFrequency int
 
-	// statsUrl is the stats api endpoint
+	// statsUrl is the stats api endpoint - default is
+	// deferclient.StatsPath on the api of the BaseClient
 	statsUrl string
 
 	// GrabGC determines if we should grab gc stats
@@ -69,6 +119,96 @@ type Client struct {
 	// GrabExpvar determines if we should grab expvar
 	GrabExpvar bool
 
+	// GrabHandler determines if we should grab the name of the handler
+	// serving each http request
+	GrabHandler bool
+
+	// DisableSpans skips generating span ids && reading parent span ids
+	// of http requests, eg: for services w/o tracing - ids are zero
+	DisableSpans bool
+
+	// EchoSpanHeader determines if we should send the span id of each
+	// http request back to the caller in a SpanHeader response header
+	EchoSpanHeader bool
+
+	// SpanHeader is the response header EchoSpanHeader uses - default is
+	// X-Dpspanid
+	SpanHeader string
+
+	// ParentSpanHeader is the request header parent span ids are read
+	// from && OutboundSpanHeader the one a Transport sends them in, eg:
+	// when a gateway renames them between hops - default is
+	// X-Dpparentspanid for both
+	ParentSpanHeader   string
+	OutboundSpanHeader string
+
+	// TraceIdHeader is the request header string trace ids, eg: 128-bit
+	// ids of other tracing systems, are read from - default is none
+	TraceIdHeader string
+
+	// TraceIdGenerator generates the string trace id of requests w/o one
+	// in TraceIdHeader, eg: NewTraceId - default is none
+	TraceIdGenerator func() string
+
+	// latencyBuckets are the upper bounds, in milliseconds, of the http
+	// latency histogram buckets - set w/SetLatencyBuckets
+	latencyBuckets []int
+
+	// MaxHeaderValueLength truncates each captured request header value
+	// to this many bytes - default is 0 (no limit)
+	MaxHeaderValueLength int
+
+	// LatencyThreshold is the latency in milliseconds at or over which a
+	// http request is always recorded - default is 0 (record everything)
+	LatencyThreshold int
+
+	// SlowRequestThreshold is the latency in milliseconds at or over
+	// which a http request is flagged as a problem, whatever its status
+	// - default is 0 (latency never flags a problem)
+	SlowRequestThreshold int
+
+	// SampleRate is the fraction (0.0 - 1.0) of http requests under
+	// LatencyThreshold that are still recorded so the fast majority of
+	// requests is represented - default is 0
+	SampleRate float64
+
+	// SlowRecoveryThreshold is the time from a panic in HTTPHandler to its
+	// 500 response being written over which the recovery is logged &&
+	// counted in SlowRecoveries - default is 0 (don't time recoveries)
+	SlowRecoveryThreshold time.Duration
+
+	// FlushThreshold is the number of recorded http requests that
+	// triggers capturing the stats before the next tick, eg: during a
+	// burst - default is 0 (only capture on the tick)
+	FlushThreshold int
+
+	// IgnoreMethods are the http methods whose requests aren't recorded,
+	// eg: OPTIONS for CORS preflights && HEAD probes - default is none
+	IgnoreMethods []string
+
+	// ShedAboveRate is the number of http requests per second over which
+	// BeforeRequest stops copying headers && generating span ids, only
+	// latency && status are recorded - default is 0 (never shed)
+	ShedAboveRate int
+
+	// RouteParams extracts the path parameters of a http request resolved
+	// by the router, eg: chi's URLParam or gorilla's Vars, to attach them
+	// to its DeferHTTP && panic report - the router only resolves them
+	// for the handlers it routes to, so wrap those - default is nil (no
+	// params)
+	RouteParams func(r *http.Request) map[string]string
+
+	// RedactRouteParams are the route params whose values are redacted,
+	// eg: tokens in the path - default is none
+	RedactRouteParams []string
+
+	// recent keeps the last http requests, see KeepRecentRequests
+	recent *recentRing
+
+	// reservoir keeps a uniform sample of the http requests, see
+	// SampleReservoir
+	reservoir *reservoir
+
 	// LastGC keeps track of the last GC run
 	LastGC int64
 
@@ -86,6 +226,10 @@ type Client struct {
 	// services - default is default
 	appGroup string
 
+	// service tags the http requests && panics of a subtree of handlers,
+	// see WithService
+	service string
+
 	// noPost when set to true disables reporting to deferpanic - useful
 	// for dev/test envs
 	noPost bool
@@ -102,7 +246,6 @@ func NewClient(token string, mux *bone.Mux) *Client {
 
 	ds := &Client{
 		statsFrequency: 60,
-		statsUrl:       deferclient.ApiBase + "/stats/create",
 		GrabGC:         true,
 		GrabMem:        true,
 		GrabGR:         true,
@@ -110,6 +253,8 @@ func NewClient(token string, mux *bone.Mux) *Client {
 		GrabFd:         true,
 		GrabHTTP:       true,
 		GrabExpvar:     false,
+		SpanHeader:     defaultSpanHeader,
+		latencyBuckets: defaultLatencyBuckets,
 		Verbose:        false,
 		Token:          token,
 		environment:    "production",
@@ -155,11 +300,33 @@ func (c *Client) SetappGroup(appGroup string) {
 	c.BaseClient.AppGroup = c.appGroup
 }
 
+// WithService returns a copy of the client tagging the http requests &&
+// panics it records w/the service name, eg: for the handlers of one of
+// the services mounted on a router
+// the copy shares the base client, the http stats && the counters, eg:
+// of the in-flight requests for DrainWithin && ShedAboveRate
+func (c *Client) WithService(name string) *Client {
+	dup := *c
+	dup.service = name
+	dup.parent = c.root()
+	return &dup
+}
+
+// root returns the client the counters are kept on, the one WithService
+// was called on
+func (c *Client) root() *Client {
+	if c.parent != nil {
+		return c.parent
+	}
+
+	return c
+}
+
 // Setnopost disables reporting to deferpanic
 // default is false
 func (c *Client) SetnoPost(noPost bool) {
 	c.noPost = noPost
-	c.BaseClient.NoPost = c.noPost
+	c.BaseClient.SetNoPost(c.noPost)
 }
 
 // CaptureStats POSTs DeferStats every statsFrequency
@@ -187,24 +354,66 @@ func (c *Client) CaptureStats() {
 	}
 }
 
+// statsURL returns the url stats are posted to
+func (c *Client) statsURL() string {
+	if c.statsUrl != "" {
+		return c.statsUrl
+	}
+
+	return c.BaseClient.APIURL(deferclient.StatsPath)
+}
+
 // updateAgent sets the agent details
 func (c *Client) updateAgent() {
-	if c.noPost {
+	// eg: w/a deferclient.NewPanicOnlyClient
+	if c.noPost || c.BaseClient.Agent == nil {
 		return
 	}
 
 	b, err := json.Marshal(c.BaseClient.Agent)
 	if err != nil {
 		log.Println(err)
+		return
 	}
 
-	agentUrl := deferclient.ApiBase + "/agent_ids/create"
-
-	c.BaseClient.Postit(b, agentUrl, false)
+	c.BaseClient.PostitKind(b, c.BaseClient.APIURL(deferclient.AgentPath), deferclient.ReportAgent, false)
 }
 
 // capture does a one time collection of DeferStats
 func (c *Client) capture() {
+	ds, ok := c.collect()
+	if ok {
+		go c.ship(ds, true)
+	}
+}
+
+// Flush ships the stats collected so far right away and waits, up to
+// timeout, for them and any panics still being reported to deferpanic
+// it returns false if some were not delivered in time
+func (c *Client) Flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	done := make(chan bool, 1)
+	go func() {
+		ds, ok := c.collect()
+		if ok {
+			c.ship(ds, false)
+		}
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		return false
+	}
+
+	return c.BaseClient.Flush(deadline.Sub(time.Now()))
+}
+
+// collect gathers DeferStats and resets the lists of http requests and
+// db queries
+func (c *Client) collect() (ds DeferStats, ok bool) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			err := fmt.Sprintf("%q", rec)
@@ -244,7 +453,7 @@ func (c *Client) capture() {
 		fds = strconv.Itoa(openFileCnt())
 	}
 
-	ds := DeferStats{
+	ds = DeferStats{
 		Mem:        mems,
 		GoRoutines: grs,
 		Cgos:       cgos,
@@ -258,11 +467,22 @@ func (c *Client) capture() {
 
 	if c.GrabHTTP {
 		dhs := curlist.List()
-		ds.HTTPs = getHTTPPercentiles(dhs)
+		ds.HTTPs = c.httpPercentiles(dhs)
+		ds.OutboundHTTPs = c.httpPercentiles(outlist.List())
+		ds.LatencyBuckets = c.buckets()
 		ds.Rpms = rpms.List()
+		ds.InFlight = strconv.FormatInt(c.InFlight(), 10)
+		ds.SlowRecoveries = atomic.SwapInt64(&c.root().slowRecoveries, 0)
+
+		if c.reservoir != nil {
+			sample, seen := c.reservoir.Reset()
+			ds.ReservoirHTTPs = c.httpPercentiles(sample)
+			ds.ReservoirSeen = seen
+		}
 
-		// reset http list && rpm
+		// reset http lists && rpm
 		curlist.Reset()
+		outlist.Reset()
 		rpms.ResetRPM()
 	}
 
@@ -280,19 +500,23 @@ func (c *Client) capture() {
 		ds.LastPause = strconv.FormatInt(gc.Pause[0].Nanoseconds(), 10)
 	}
 
-	go func() {
-		defer func() {
-			if rec := recover(); rec != nil {
-				err := fmt.Sprintf("%q", rec)
-				log.Println(err)
-			}
-		}()
-
-		b, err := json.Marshal(ds)
-		if err != nil {
+	return ds, true
+}
+
+// ship POSTs ds to deferpanic, analyseResponse executes any commands
+// returned
+func (c *Client) ship(ds DeferStats, analyseResponse bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err := fmt.Sprintf("%q", rec)
 			log.Println(err)
 		}
-
-		c.BaseClient.Postit(b, c.statsUrl, true)
 	}()
+
+	b, err := json.Marshal(ds)
+	if err != nil {
+		log.Println(err)
+	}
+
+	c.BaseClient.PostitKind(b, c.statsURL(), deferclient.ReportStats, analyseResponse)
 }
@@ -5,9 +5,13 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"runtime"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/betacraft/deferclient/deferclient"
 )
 
 func TestClient(t *testing.T) {
@@ -82,3 +86,85 @@ func TestClient(t *testing.T) {
 	}
 
 }
+
+func TestStatsURL(t *testing.T) {
+	dps := NewClient("token", nil)
+
+	var respath = make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respath <- r.URL.Path
+	}))
+	defer ts.Close()
+
+	dps.BaseClient.SetBaseURL(ts.URL)
+
+	if dps.GetStatsURL() != ts.URL+deferclient.StatsPath {
+		t.Errorf("not following the base url, got %v", dps.GetStatsURL())
+	}
+
+	dps.updateAgent()
+	if !dps.Flush(2 * time.Second) {
+		t.Error("not flushing in time")
+	}
+
+	if <-respath != deferclient.AgentPath || <-respath != deferclient.StatsPath {
+		t.Error("not posting to the base url")
+	}
+
+	// panic only clients have no agent to check in
+	dps.BaseClient = deferclient.NewPanicOnlyClient("token")
+	dps.BaseClient.SetBaseURL(ts.URL)
+
+	dps.updateAgent()
+	if len(respath) != 0 {
+		t.Errorf("checking in a nil agent, got %v", <-respath)
+	}
+}
+
+// kindsReporter records the kinds of the reports it is sent
+type kindsReporter chan deferclient.ReportKind
+
+func (r kindsReporter) Send(body []byte, kind deferclient.ReportKind) error {
+	r <- kind
+	return nil
+}
+
+func TestReportKinds(t *testing.T) {
+	kinds := make(kindsReporter, 2)
+
+	dps := NewClient("token", nil)
+	dps.SetStatsURL("http://127.0.0.1:0/custom/stats")
+	dps.BaseClient.Reporter = kinds
+
+	dps.updateAgent()
+	dps.ship(DeferStats{}, false)
+
+	if len(kinds) != 2 || <-kinds != deferclient.ReportAgent || <-kinds != deferclient.ReportStats {
+		t.Error("not reporting the kinds of the stats && agent reports")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dps := NewClient("token", nil)
+
+	var resbody = make(chan []byte, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		resbody <- body
+	}))
+	defer ts.Close()
+
+	dps.statsUrl = ts.URL
+
+	if !dps.Flush(2 * time.Second) {
+		t.Error("not flushing in time")
+	}
+
+	select {
+	case <-resbody:
+	default:
+		t.Error("not shipping the stats on flush")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build go1.21
+// +build go1.21
+
+package deferstats
+
+import (
+	"crypto/tls"
+)
+
+// tlsVersionName returns the name of the tls version, eg: TLS 1.3
+func tlsVersionName(version uint16) string {
+	return tls.VersionName(version)
+}
+
+// tlsCipherName returns the name of the tls cipher suite, eg:
+// TLS_AES_128_GCM_SHA256
+func tlsCipherName(cipher uint16) string {
+	return tls.CipherSuiteName(cipher)
+}
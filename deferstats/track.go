@@ -0,0 +1,49 @@
+package deferstats
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Track runs fn and records its latency under name just like a http
+// request, eg: for background jobs && queue consumers
+// an error returned by fn flags the run as a problem, a panic is sent to
+// deferpanic and returned as an error
+func (c *Client) Track(name string, fn func() error) (err error) {
+	startTime := time.Now()
+
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			c.BaseClient.PrepWithService(rec, 0, "", c.service)
+			err = fmt.Errorf("%v", rec)
+		}
+
+		dh := DeferHTTP{
+			Path:      name,
+			IsProblem: err != nil,
+		}
+		if rec != nil {
+			dh.ProblemKind = ProblemPanic
+		}
+
+		c.record(startTime, dh)
+	}()
+
+	return fn()
+}
+
+// RecordSpan records a sub-operation of the span parentSpanId, eg: a db
+// call or a cache lookup, as a child span named name from start to end
+func (c *Client) RecordSpan(parentSpanId int64, name string, start, end time.Time, isProblem bool) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	c.add(DeferHTTP{
+		Path:         name,
+		Time:         int(end.Sub(start).Nanoseconds() / 1000000),
+		SpanId:       r.Int63(),
+		ParentSpanId: parentSpanId,
+		IsProblem:    isProblem,
+	})
+}
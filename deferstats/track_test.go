@@ -0,0 +1,136 @@
+package deferstats
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betacraft/deferclient/deferclient"
+)
+
+func TestTrack(t *testing.T) {
+	curlist.Reset()
+	rpms.ResetRPM()
+	defer rpms.ResetRPM()
+
+	c := &Client{BaseClient: deferclient.NewDeferPanicClient("token")}
+	c.BaseClient.NoPost = true
+
+	err := c.Track("job", func() error {
+		return nil
+	})
+	if err != nil {
+		t.Error("not returning the result of the job")
+	}
+
+	err = c.Track("failing job", func() error {
+		return errors.New("failed")
+	})
+	if err == nil || err.Error() != "failed" {
+		t.Error("not returning the error of the job")
+	}
+
+	err = c.Track("panicking job", func() error {
+		panic("there is no need to panic")
+	})
+	if err == nil {
+		t.Error("not recovering the panic of the job")
+	}
+
+	list := curlist.List()
+	if len(list) != 3 {
+		t.Fatal("not recording the jobs")
+	}
+
+	if list[0].Path != "job" || list[0].IsProblem {
+		t.Error("not recording a successful job")
+	}
+
+	if !list[1].IsProblem || !list[2].IsProblem {
+		t.Error("not flagging failed jobs as problems")
+	}
+
+	if list[1].ProblemKind != ProblemError || list[2].ProblemKind != ProblemPanic {
+		t.Errorf("not telling the problems of the jobs apart, got %v, %v", list[1].ProblemKind, list[2].ProblemKind)
+	}
+
+	if _, ok := rpms.List().Latencies[0]; ok {
+		t.Error("observing the latency of jobs w/o a status")
+	}
+
+	c.AddHTTP(NewDeferHTTP("GET /", "GET", 502, time.Millisecond, 0, 0, true))
+	if dh := curlist.List()[3]; dh.ProblemKind != ProblemErrorStatus {
+		t.Errorf("not setting the kind of added problems, got %v", dh.ProblemKind)
+	}
+}
+
+func TestRecordSpan(t *testing.T) {
+	curlist.Reset()
+	defer curlist.Reset()
+
+	c := &Client{}
+
+	start := time.Now()
+	c.RecordSpan(42, "db query", start, start.Add(25*time.Millisecond), true)
+
+	list := curlist.List()
+	if len(list) != 1 {
+		t.Fatal("not recording the span")
+	}
+
+	span := list[0]
+	if span.ParentSpanId != 42 || span.SpanId == 0 {
+		t.Error("not linking the span to its parent")
+	}
+
+	if span.Path != "db query" || span.Time != 25 || !span.IsProblem {
+		t.Error("not recording the span details")
+	}
+}
+
+func TestWithService(t *testing.T) {
+	curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{BaseClient: deferclient.NewDeferPanicClient("token")}
+	c.BaseClient.NoPost = true
+
+	billing := c.WithService("billing")
+	if billing.BaseClient != c.BaseClient {
+		t.Error("not sharing the base client")
+	}
+
+	billing.Track("invoice", func() error { return nil })
+	c.Track("job", func() error { return nil })
+
+	list := curlist.List()
+	if len(list) != 2 || list[0].Service != "billing" || list[1].Service != "" {
+		t.Errorf("not tagging the requests of the service, got %+v", list)
+	}
+}
+
+func TestWithServiceShared(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	billing := c.WithService("billing")
+
+	var inFlight int64
+	h := billing.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight = c.InFlight()
+
+		defer c.BaseClient.Recover(r.Context())
+		panic("invoice")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/invoices", nil))
+
+	if inFlight != 1 || c.InFlight() != 0 {
+		t.Errorf("not counting the requests of the service in flight, got %d", inFlight)
+	}
+
+	if dj := <-reports; dj.Service != "billing" {
+		t.Errorf("not tagging the panics recovered from the request context, got %q", dj.Service)
+	}
+}
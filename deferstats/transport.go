@@ -0,0 +1,185 @@
+package deferstats
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/betacraft/deferclient/deferclient"
+)
+
+// Transport propagates the span id && the baggage carried by the context
+// of the requests it sends to the services they call, eg: w/the context
+// of a request served by HTTPHandler
+type Transport struct {
+	// Base sends the requests - default is http.DefaultTransport
+	Base http.RoundTripper
+
+	// Stats records the requests sent, under "METHOD host" - default is
+	// nil (don't record them)
+	Stats *Client
+
+	// SpanHeader is the header the span id is sent in - default is the
+	// OutboundSpanHeader of Stats or X-Dpparentspanid
+	SpanHeader string
+
+	// Timings breaks down the latency of the recorded requests into dns,
+	// connect, tls handshake && time to first byte - default is false
+	Timings bool
+}
+
+// spanHeader returns the header the span id is sent in
+func (t *Transport) spanHeader() string {
+	if t.SpanHeader != "" {
+		return t.SpanHeader
+	}
+
+	if t.Stats != nil {
+		return t.Stats.outboundSpanHeader()
+	}
+
+	return defaultParentSpanHeader
+}
+
+// OutboundTimings break the latency of an outbound request down, in
+// milliseconds, zero for the steps skipped, eg: by a kept-alive connection
+type OutboundTimings struct {
+	DNS          int `json:"DNS"`
+	Connect      int `json:"Connect"`
+	TLSHandshake int `json:"TLSHandshake"`
+	FirstByte    int `json:"FirstByte"`
+}
+
+// timingsTrace returns a httptrace.ClientTrace filling timings, for a
+// request started at start
+// connects can race each other, eg: for dual-stack hosts, hence the lock
+func timingsTrace(start time.Time, timings *OutboundTimings, lock *sync.Mutex) *httptrace.ClientTrace {
+	var dnsStart, connectStart, tlsStart time.Time
+
+	ms := func(since time.Time) int {
+		return int(time.Since(since).Nanoseconds() / 1000000)
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock.Lock()
+			dnsStart = time.Now()
+			lock.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock.Lock()
+			timings.DNS = ms(dnsStart)
+			lock.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			lock.Lock()
+			connectStart = time.Now()
+			lock.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			lock.Lock()
+			timings.Connect = ms(connectStart)
+			lock.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			lock.Lock()
+			tlsStart = time.Now()
+			lock.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock.Lock()
+			timings.TLSHandshake = ms(tlsStart)
+			lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			lock.Lock()
+			timings.FirstByte = ms(start)
+			lock.Unlock()
+		},
+	}
+}
+
+// RoundTrip sets the X-Dpparentspanid && X-Dpbaggage headers of req
+// before sending it w/Base
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	spanId := deferclient.SpanIdFromContext(req.Context())
+	baggage := deferclient.FormatBaggage(deferclient.BaggageFromContext(req.Context()))
+
+	if spanId != 0 || baggage != "" {
+		// a RoundTripper must not modify the request
+		req = cloneRequest(req)
+
+		if spanId != 0 {
+			req.Header.Set(t.spanHeader(), strconv.FormatInt(spanId, 10))
+		}
+		if baggage != "" {
+			req.Header.Set(deferclient.BaggageHeader, baggage)
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if t.Stats == nil {
+		return base.RoundTrip(req)
+	}
+
+	startTime := time.Now()
+
+	var timings *OutboundTimings
+	var lock sync.Mutex
+	if t.Timings {
+		timings = &OutboundTimings{}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timingsTrace(startTime, timings, &lock)))
+	}
+
+	resp, err := base.RoundTrip(req)
+
+	dh := DeferHTTP{
+		Path:         req.Method + " " + req.URL.Host,
+		Method:       req.Method,
+		ParentSpanId: spanId,
+		Host:         req.URL.Host,
+		Outbound:     true,
+	}
+	if resp != nil {
+		dh.StatusCode = resp.StatusCode
+	}
+	if err != nil || dh.IsServerError() {
+		dh.IsProblem = true
+		dh.ProblemKind = ProblemErrorStatus
+		if err != nil {
+			dh.ProblemKind = ProblemError
+		}
+	}
+	if timings != nil {
+		// a losing connect may still be reporting
+		lock.Lock()
+		snapshot := *timings
+		lock.Unlock()
+		dh.Timings = &snapshot
+	}
+
+	t.Stats.record(startTime, dh)
+
+	return resp, err
+}
+
+// cloneRequest returns a copy of req w/its own headers to set, as
+// req.Clone does w/o needing go 1.13
+func cloneRequest(req *http.Request) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *req
+
+	r2.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r2.Header[k] = append([]string(nil), v...)
+	}
+
+	return r2
+}
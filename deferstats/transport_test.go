@@ -0,0 +1,146 @@
+package deferstats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/betacraft/deferclient/deferclient"
+)
+
+func TestBaggagePropagation(t *testing.T) {
+	c, reports, done := reportingClient(t)
+	defer done()
+
+	downstream := httptest.NewServer(c.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("downstream")
+	})))
+	defer downstream.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+
+	upstream := httptest.NewServer(c.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest("GET", downstream.URL, nil)
+		req = req.WithContext(r.Context())
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	})))
+	defer upstream.Close()
+
+	req, _ := http.NewRequest("GET", upstream.URL, nil)
+	req.Header.Set(deferclient.BaggageHeader, "tenant=acme, flag=on")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	dj := <-reports
+	if dj.Baggage["tenant"] != "acme" || dj.Baggage["flag"] != "on" {
+		t.Errorf("not reporting the baggage downstream, got %v", dj.Baggage)
+	}
+
+	if dj.SpanId == 0 {
+		t.Error("not reporting the span id")
+	}
+}
+
+func TestTransportTimings(t *testing.T) {
+	curlist.Reset()
+	outlist.Reset()
+	defer curlist.Reset()
+	defer outlist.Reset()
+	defer rpms.ResetRPM()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	before := rpms.List()
+
+	base := ts.Client().Transport
+	client := &http.Client{Transport: &Transport{Base: base, Stats: c, Timings: true}}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !reflect.DeepEqual(rpms.List(), before) {
+		t.Errorf("counting the outbound request in the rpms, got %+v", rpms.List())
+	}
+
+	if curlist.Len() != 0 {
+		t.Error("mixing the outbound request w/the requests handled")
+	}
+
+	list := outlist.List()
+	if len(list) != 1 {
+		t.Fatal("not recording the outbound request")
+	}
+
+	dh := list[0]
+	if !dh.Outbound || dh.StatusCode != 503 || !dh.IsProblem || dh.Path != "GET "+resp.Request.URL.Host {
+		t.Errorf("not recording the outbound request, got %+v", dh)
+	}
+
+	if dh.Timings == nil {
+		t.Fatal("not recording the timings")
+	}
+
+	c.GrabHTTP = true
+	ds, _ := c.collect()
+	if len(ds.OutboundHTTPs) != 1 || len(ds.HTTPs) != 0 {
+		t.Errorf("not shipping the outbound request apart, got %+v", ds)
+	}
+}
+
+func TestSpanHeaders(t *testing.T) {
+	curlist.Reset()
+	defer curlist.Reset()
+	defer rpms.ResetRPM()
+
+	c := &Client{ParentSpanHeader: "x-inbound-span", OutboundSpanHeader: "X-Outbound-Span"}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Inbound-Span", "42")
+
+	_, tracer, _ := c.BeforeRequest(httptest.NewRecorder(), r)
+	if tracer.ParentSpanId != 42 {
+		t.Error("not reading the parent span id from ParentSpanHeader")
+	}
+
+	var sent = make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent <- r.Header.Get("X-Outbound-Span")
+	}))
+	defer ts.Close()
+
+	ctx := deferclient.ContextWithSpanId(r.Context(), tracer.SpanId)
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req = req.WithContext(ctx)
+
+	client := &http.Client{Transport: &Transport{Stats: c}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if <-sent != strconv.FormatInt(tracer.SpanId, 10) {
+		t.Error("not sending the span id in OutboundSpanHeader")
+	}
+	if req.Header.Get("X-Outbound-Span") != "" {
+		t.Error("setting the span id on the request of the caller")
+	}
+}